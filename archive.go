@@ -8,5 +8,6 @@ import (
 
 type Archivist interface {
 	ArchivePhotos(flickr.API, ...photo.Photo) error
+	ArchivePhotosWithContext(context.Context, flickr.API, ...photo.Photo) error
 	ArchivePhoto(context.Context, flickr.API, photo.Photo) error
 }
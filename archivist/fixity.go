@@ -0,0 +1,252 @@
+package archivist
+
+import (
+	"context"
+	"crypto/sha256"
+	"github.com/aaronland/go-flickr-archive/util"
+	"github.com/aaronland/go-storage"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+)
+
+const FIXITY_OK string = "ok"
+
+// the file exists but its manifest predates checksums
+const FIXITY_UNVERIFIED string = "unverified"
+
+const FIXITY_MISSING string = "missing"
+
+const FIXITY_TRUNCATED string = "truncated"
+
+// the file is the wrong size (but not shorter) or its checksum doesn't match
+const FIXITY_CORRUPTED string = "corrupted"
+
+// there are files for a photo but no manifest, see Manifest
+const FIXITY_UNCOMMITTED string = "uncommitted"
+
+// FixityReport is the result of checking one file against its manifest or,
+// for FIXITY_UNCOMMITTED, one photo that doesn't have a manifest.
+
+type FixityReport struct {
+	ID       int64         `json:"id"`
+	Key      string        `json:"key"`
+	Manifest string        `json:"manifest,omitempty"`
+	Status   string        `json:"status"`
+	Expected *ManifestFile `json:"expected,omitempty"`
+	Size     int64         `json:"size"`
+	SHA256   string        `json:"sha256,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+func (r *FixityReport) OK() bool {
+	return r.Status == FIXITY_OK || r.Status == FIXITY_UNVERIFIED
+}
+
+type FixityCallbackFunc func(*FixityReport) error
+
+// Verify walks store checking every file listed in every manifest, and calls
+// cb with the result for each one, followed by a FIXITY_UNCOMMITTED report
+// for each photo that has files but no manifest. Problems with files are
+// reported to cb rather than returned; errors are only returned if store
+// can't be walked or cb returns one.
+
+func Verify(ctx context.Context, store storage.Store, cb FixityCallbackFunc) error {
+
+	manifests := make([]string, 0)
+	others := make(map[int64]string)
+
+	walk_cb := func(p string, args ...interface{}) error {
+
+		key := util.StoreKey(store, p)
+
+		if strings.HasPrefix(key, "_") {
+			return nil
+		}
+
+		if IsManifest(key) {
+			manifests = append(manifests, key)
+			return nil
+		}
+
+		id, ok := PhotoIDFromFilename(key)
+
+		if ok {
+
+			_, seen := others[id]
+
+			if !seen {
+				others[id] = key
+			}
+		}
+
+		return nil
+	}
+
+	err := store.Walk(walk_cb)
+
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(manifests)
+
+	committed := make(map[int64]bool)
+
+	for _, manifest_key := range manifests {
+
+		m, err := ReadManifest(store, manifest_key)
+
+		if err != nil {
+
+			r := FixityReport{
+				Key:    manifest_key,
+				Status: FIXITY_CORRUPTED,
+				Error:  err.Error(),
+			}
+
+			id, ok := PhotoIDFromFilename(manifest_key)
+
+			if ok {
+				r.ID = id
+				committed[id] = true
+			}
+
+			err = cb(&r)
+
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		committed[m.ID] = true
+
+		for _, f := range m.Files {
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+				// pass
+			}
+
+			key := path.Join(path.Dir(manifest_key), f.Name)
+
+			r := VerifyFile(store, key, f)
+			r.ID = m.ID
+			r.Manifest = manifest_key
+
+			err := cb(r)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	ids := make([]int64, 0)
+
+	for id := range others {
+
+		if !committed[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+
+		r := FixityReport{
+			ID:     id,
+			Key:    others[id],
+			Status: FIXITY_UNCOMMITTED,
+		}
+
+		err := cb(&r)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// VerifyFile compares key in store with what its manifest says it should be.
+
+func VerifyFile(store storage.Store, key string, expected *ManifestFile) *FixityReport {
+
+	r := FixityReport{
+		Key:      key,
+		Expected: expected,
+	}
+
+	exists, err := store.Exists(key)
+
+	if err != nil {
+		r.Status = FIXITY_MISSING
+		r.Error = err.Error()
+		return &r
+	}
+
+	if !exists {
+		r.Status = FIXITY_MISSING
+		return &r
+	}
+
+	fh, err := store.Get(key)
+
+	if err != nil {
+		r.Status = FIXITY_MISSING
+		r.Error = err.Error()
+		return &r
+	}
+
+	hr := util.NewHashingReader(fh, sha256.New())
+	_, err = io.Copy(ioutil.Discard, hr)
+
+	hr.Close()
+
+	if err != nil {
+		r.Status = FIXITY_CORRUPTED
+		r.Error = err.Error()
+		return &r
+	}
+
+	actual := manifestFile(key, hr)
+
+	r.Size = actual.Size
+	r.SHA256 = actual.SHA256
+
+	switch {
+	case expected.SHA256 == "":
+		r.Status = FIXITY_UNVERIFIED
+	case actual.Size < expected.Size:
+		r.Status = FIXITY_TRUNCATED
+	case actual.Size != expected.Size || actual.SHA256 != expected.SHA256:
+		r.Status = FIXITY_CORRUPTED
+	default:
+		r.Status = FIXITY_OK
+	}
+
+	return &r
+}
+
+// manifestFile returns the manifest entry for key, assuming everything in it
+// has been read through hr, which is hashing SHA-256.
+
+func manifestFile(key string, hr *util.HashingReader) *ManifestFile {
+
+	f := ManifestFile{
+		Name:   path.Base(key),
+		Size:   hr.Size(),
+		SHA256: hr.Checksum(),
+	}
+
+	return &f
+}
@@ -0,0 +1,285 @@
+package archivist
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-flickr-archive/photo"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// the original layout, one directory per photo at the root of the store
+
+const LAYOUT_ID string = "id"
+
+const LAYOUT_FLAT string = "flat"
+
+const LAYOUT_SHARDED string = "sharded"
+
+const LAYOUT_DATE string = "date"
+
+// every file that gets archived for a photo has a name that starts with the
+// photo's ID which is how files are matched back up with photos
+
+var re_filename = regexp.MustCompile(`^(\d+)_`)
+
+// Layout decides where in a store the files for a photo go.
+
+type Layout interface {
+	// Path returns the key for fname, one of the files for ph.
+	Path(ph *photo.FlickrPhoto, fname string) (string, error)
+}
+
+type LayoutFunc func(*photo.FlickrPhoto) (string, error)
+
+// funcLayout is a Layout that puts every file for a photo in the same
+// directory.
+
+type funcLayout struct {
+	Layout
+	dir LayoutFunc
+}
+
+func (l *funcLayout) Path(ph *photo.FlickrPhoto, fname string) (string, error) {
+
+	dir, err := l.dir(ph)
+
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(dir, fname), nil
+}
+
+func NewLayoutWithFunc(fn LayoutFunc) Layout {
+
+	l := funcLayout{
+		dir: fn,
+	}
+
+	return &l
+}
+
+// NewLayout returns one of the built-in layouts or, if name contains "{{", a
+// template layout.
+
+func NewLayout(name string) (Layout, error) {
+
+	if strings.Contains(name, "{{") {
+		return NewTemplateLayout(name)
+	}
+
+	switch name {
+	case LAYOUT_ID, "":
+		return NewIDLayout(), nil
+	case LAYOUT_FLAT:
+		return NewFlatLayout(), nil
+	case LAYOUT_SHARDED:
+		return NewShardedLayout(), nil
+	case LAYOUT_DATE:
+		return NewDateLayout(), nil
+	default:
+		msg := fmt.Sprintf("Invalid layout '%s'", name)
+		return nil, errors.New(msg)
+	}
+}
+
+func DefaultLayout() Layout {
+	return NewIDLayout()
+}
+
+// NewIDLayout returns the layout that {id}/{filename} has always been.
+
+func NewIDLayout() Layout {
+
+	fn := func(ph *photo.FlickrPhoto) (string, error) {
+		return strconv.FormatInt(ph.ID, 10), nil
+	}
+
+	return NewLayoutWithFunc(fn)
+}
+
+// NewFlatLayout puts every file in the root of the store.
+
+func NewFlatLayout() Layout {
+
+	fn := func(ph *photo.FlickrPhoto) (string, error) {
+		return "", nil
+	}
+
+	return NewLayoutWithFunc(fn)
+}
+
+// NewShardedLayout puts 123456789 in 123/456/789/123456789.
+
+func NewShardedLayout() Layout {
+
+	fn := func(ph *photo.FlickrPhoto) (string, error) {
+		return ShardID(ph.ID), nil
+	}
+
+	return NewLayoutWithFunc(fn)
+}
+
+// NewDateLayout puts photos in {owner}/{yyyy}/{mm}/{dd}/{id} using the date
+// the photo was taken, which means it needs ph.Owner and ph.Dates. Those come
+// from flickr.photos.getInfo or a search with the "date_taken" extra.
+
+func NewDateLayout() Layout {
+
+	fn := func(ph *photo.FlickrPhoto) (string, error) {
+
+		if ph.Owner == nil || ph.Owner.NSID == "" {
+			msg := fmt.Sprintf("Can not determine owner for photo %d", ph.ID)
+			return "", errors.New(msg)
+		}
+
+		if ph.Dates == nil || ph.Dates.Taken == "" {
+			msg := fmt.Sprintf("Can not determine date taken for photo %d", ph.ID)
+			return "", errors.New(msg)
+		}
+
+		// dates taken are wall-clock times so there's no zone to
+		// convert from (or to)
+
+		t, err := ph.Dates.TakenTime(time.UTC)
+
+		if err != nil {
+			return "", err
+		}
+
+		dir := path.Join(ph.Owner.NSID, t.Format("2006/01/02"), strconv.FormatInt(ph.ID, 10))
+		return dir, nil
+	}
+
+	return NewLayoutWithFunc(fn)
+}
+
+// LayoutTemplateVars are what template layouts have to work with. The
+// template should produce the directory for a photo, for example:
+//
+// {{.Owner}}/{{.Year}}/{{.ID}}
+// {{shard .ID}}
+
+type LayoutTemplateVars struct {
+	ID     int64
+	Secret string
+	Owner  string
+	Year   string
+	Month  string
+	Day    string
+	Media  string
+	Photo  *photo.FlickrPhoto
+}
+
+var layout_funcs = template.FuncMap{
+	"shard": ShardID,
+}
+
+// NewTemplateLayout returns a layout that runs a Go template to work out the
+// directory for each photo. Year, Month and Day are empty if the date taken
+// isn't known, as is Owner.
+
+func NewTemplateLayout(text string) (Layout, error) {
+
+	t, err := template.New("layout").Funcs(layout_funcs).Option("missingkey=error").Parse(text)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fn := func(ph *photo.FlickrPhoto) (string, error) {
+
+		vars := LayoutTemplateVars{
+			ID:     ph.ID,
+			Secret: ph.ArchiveSecret(),
+			Media:  ph.Media,
+			Photo:  ph,
+		}
+
+		if ph.Owner != nil {
+			vars.Owner = ph.Owner.NSID
+		}
+
+		if ph.Dates != nil && ph.Dates.Taken != "" {
+
+			taken, err := ph.Dates.TakenTime(time.UTC)
+
+			if err == nil {
+				vars.Year = taken.Format("2006")
+				vars.Month = taken.Format("01")
+				vars.Day = taken.Format("02")
+			}
+		}
+
+		var buf bytes.Buffer
+
+		err := t.Execute(&buf, vars)
+
+		if err != nil {
+			return "", err
+		}
+
+		dir := path.Clean("/" + strings.TrimSpace(buf.String()))
+		return strings.TrimLeft(dir, "/"), nil
+	}
+
+	// catch things like misspelled fields now rather than in the middle
+	// of archiving something
+
+	_, err = fn(&photo.FlickrPhoto{ID: 1})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLayoutWithFunc(fn), nil
+}
+
+// ShardID splits id in to three digit chunks so 123456789 becomes
+// 123/456/789/123456789 and 52345678901 becomes 523/456/789/01/52345678901.
+
+func ShardID(id int64) string {
+
+	str_id := strconv.FormatInt(id, 10)
+	parts := make([]string, 0)
+
+	for i := 0; i < len(str_id); i += 3 {
+
+		j := i + 3
+
+		if j > len(str_id) {
+			j = len(str_id)
+		}
+
+		parts = append(parts, str_id[i:j])
+	}
+
+	parts = append(parts, str_id)
+	return strings.Join(parts, "/")
+}
+
+// PhotoIDFromFilename returns the ID of the photo that an archived file
+// belongs to, or false if fname doesn't look like one of ours.
+
+func PhotoIDFromFilename(fname string) (int64, bool) {
+
+	m := re_filename.FindStringSubmatch(path.Base(fname))
+
+	if m == nil {
+		return 0, false
+	}
+
+	id, err := strconv.ParseInt(m[1], 10, 64)
+
+	if err != nil {
+		return 0, false
+	}
+
+	return id, true
+}
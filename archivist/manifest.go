@@ -0,0 +1,133 @@
+package archivist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-flickr-archive/photo"
+	"github.com/aaronland/go-flickr-archive/util"
+	"github.com/aaronland/go-storage"
+	"strings"
+	"time"
+)
+
+// the manifest for a photo is the last thing written when it's archived and
+// the first thing removed when it's archived again, so a photo only counts as
+// archived if its manifest exists. Anything else in the photo's directory
+// without one is left over from an archive that didn't finish.
+
+const MANIFEST_SUFFIX string = "_m.json"
+
+type Manifest struct {
+	ID         int64           `json:"id"`
+	LastUpdate int64           `json:"lastupdate,omitempty"`
+	Created    int64           `json:"created"`
+	Files      []*ManifestFile `json:"files"`
+}
+
+// ManifestFile is one of the files archived for a photo. Name is relative
+// to the photo's directory so that manifests don't need rewriting when a
+// photo is moved to a different layout. Size and SHA256 are worked out as the
+// file is written, see Verify.
+
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+func NewManifest(fph *photo.FlickrPhoto) *Manifest {
+
+	m := Manifest{
+		ID:         fph.ID,
+		LastUpdate: fph.LastUpdate,
+		Files:      make([]*ManifestFile, 0),
+	}
+
+	return &m
+}
+
+// Add records that f has been written, replacing any earlier record of it.
+
+func (m *Manifest) Add(f *ManifestFile) {
+
+	for i, existing := range m.Files {
+
+		if existing.Name == f.Name {
+			m.Files[i] = f
+			return
+		}
+	}
+
+	m.Files = append(m.Files, f)
+}
+
+func ManifestFilename(fph *photo.FlickrPhoto) string {
+	return fmt.Sprintf("%d_%s%s", fph.ID, fph.ArchiveSecret(), MANIFEST_SUFFIX)
+}
+
+func IsManifest(key string) bool {
+	return strings.HasSuffix(key, MANIFEST_SUFFIX)
+}
+
+func ReadManifest(store storage.Store, key string) (*Manifest, error) {
+
+	body, err := util.ReadKey(store, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+
+	err = json.Unmarshal(body, &m)
+
+	if err != nil {
+		msg := fmt.Sprintf("Failed to parse %s: %s", key, err)
+		return nil, errors.New(msg)
+	}
+
+	return &m, nil
+}
+
+// begin removes the manifest for fph, if there is one, before anything for
+// it gets (re)written.
+
+func (arch *StaticArchivist) begin(fph *photo.FlickrPhoto) (*Manifest, error) {
+
+	key, err := arch.path(fph, ManifestFilename(fph))
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = arch.store.Delete(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewManifest(fph), nil
+}
+
+// commit writes the manifest for fph, at which point it counts as archived.
+
+func (arch *StaticArchivist) commit(fph *photo.FlickrPhoto, m *Manifest) error {
+
+	key, err := arch.path(fph, ManifestFilename(fph))
+
+	if err != nil {
+		return err
+	}
+
+	m.Created = time.Now().Unix()
+
+	enc, err := json.Marshal(m)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = arch.putBytes(key, enc)
+	return err
+}
@@ -0,0 +1,399 @@
+package archivist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-flickr-archive/photo"
+	"github.com/aaronland/go-flickr-archive/stores"
+	"github.com/aaronland/go-flickr-archive/util"
+	"github.com/aaronland/go-storage"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Relayout copies every archived file in source to where layout says it
+// should go in target. If source and target are the same store then files
+// are moved instead, one photo at a time, and nothing is removed until the
+// photo's files and manifest have all been written to their new places. It
+// doesn't matter what layout (or mix of layouts) source is in because files
+// are matched up with photos by name. Photos that can't be placed, say
+// because the date layout needs an info file that isn't there, are skipped
+// and returned as errors once everything else is done. Photos without a
+// manifest are moved along with everything else but still don't count as
+// archived. Directories on the local filesystem that a move leaves empty are
+// removed.
+
+func Relayout(ctx context.Context, source storage.Store, target storage.Store, layout Layout) error {
+
+	move := source == target
+
+	photos := make(map[int64][]string)
+	others := make([]string, 0)
+
+	cb := func(p string, args ...interface{}) error {
+
+		key := util.StoreKey(source, p)
+
+		id, ok := PhotoIDFromFilename(key)
+
+		if ok && !strings.HasPrefix(key, "_") {
+			photos[id] = append(photos[id], key)
+		} else {
+			others = append(others, key)
+		}
+
+		return nil
+	}
+
+	err := source.Walk(cb)
+
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int64, 0)
+
+	for id := range photos {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	failed := make([]error, 0)
+
+	for _, id := range ids {
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// pass
+		}
+
+		err := relayoutPhoto(source, target, layout, id, photos[id], move)
+
+		if err != nil {
+			log.Printf("Failed to relayout photo %d: %s\n", id, err)
+			failed = append(failed, err)
+		}
+	}
+
+	// things like the journal aren't tied to a layout so they only need
+	// copying if they're going somewhere else
+
+	if !move {
+
+		for _, key := range others {
+
+			err := copyKey(source, target, key, key)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return errors.Join(failed...)
+}
+
+func relayoutPhoto(source storage.Store, target storage.Store, layout Layout, id int64, keys []string, move bool) error {
+
+	ph := &photo.FlickrPhoto{
+		ID: id,
+	}
+
+	for _, key := range keys {
+
+		if !strings.HasSuffix(key, "_i.json") {
+			continue
+		}
+
+		body, err := util.ReadKey(source, key)
+
+		if err != nil {
+			return err
+		}
+
+		info_ph, err := photo.NewFlickrPhotoFromInfo(body)
+
+		if err != nil {
+			msg := fmt.Sprintf("Failed to parse %s: %s", key, err)
+			return errors.New(msg)
+		}
+
+		ph = info_ph
+		break
+	}
+
+	// work out where everything is going before touching anything
+
+	new_keys := make(map[string]string)
+
+	for _, key := range keys {
+
+		new_key, err := layout.Path(ph, path.Base(key))
+
+		if err != nil {
+			return err
+		}
+
+		new_keys[key] = new_key
+	}
+
+	// the manifest is what says a photo has been archived so it always goes
+	// in last and, when moving, the old one is only removed once the new
+	// one has been written. The old files are only removed after that, so
+	// a relayout that gets interrupted (or fails) never leaves a photo
+	// looking complete when it isn't and never loses a manifest.
+
+	manifests := make(map[string][]byte)
+
+	for _, key := range keys {
+
+		if !IsManifest(key) {
+			continue
+		}
+
+		body, err := util.ReadKey(source, key)
+
+		if err != nil {
+			return err
+		}
+
+		manifests[key] = body
+	}
+
+	for _, key := range keys {
+
+		if IsManifest(key) {
+			continue
+		}
+
+		new_key := new_keys[key]
+
+		if move && new_key == key {
+			continue
+		}
+
+		var err error
+
+		if strings.HasSuffix(key, "_v.json") {
+
+			// the rendition changes so its checksum does too
+
+			var f *ManifestFile
+			f, err = relayoutVideoRendition(source, target, layout, ph, key, new_key)
+
+			if err == nil {
+				err = updateManifests(manifests, f)
+			}
+
+		} else {
+			err = copyKey(source, target, key, new_key)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for key, body := range manifests {
+
+		_, err := util.PutKey(target, new_keys[key], body)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !move {
+		return nil
+	}
+
+	// manifests first, for the same reason as above
+
+	for key := range manifests {
+
+		if new_keys[key] == key {
+			continue
+		}
+
+		err := source.Delete(key)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range keys {
+
+		if IsManifest(key) || new_keys[key] == key {
+			continue
+		}
+
+		err := source.Delete(key)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range keys {
+
+		if new_keys[key] == key {
+			continue
+		}
+
+		err := removeEmptyDirs(source, key)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeEmptyDirs removes the directory that key was in, and then its parents,
+// for as long as they are empty. It stops at the root of the store and does
+// nothing for stores that aren't on the local filesystem, which don't have
+// directories to leave behind.
+
+func removeEmptyDirs(store storage.Store, key string) error {
+
+	switch store.(type) {
+	case *storage.FSStore, *stores.AtomicFSStore:
+		// pass
+	default:
+		return nil
+	}
+
+	root := filepath.Clean(store.URI(""))
+	dir := filepath.Dir(store.URI(key))
+
+	for strings.HasPrefix(dir, root+string(filepath.Separator)) {
+
+		entries, err := os.ReadDir(dir)
+
+		// already removed along with another file for the same photo
+
+		if os.IsNotExist(err) {
+			dir = filepath.Dir(dir)
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if len(entries) > 0 {
+			return nil
+		}
+
+		err = os.Remove(dir)
+
+		if err != nil {
+			return err
+		}
+
+		dir = filepath.Dir(dir)
+	}
+
+	return nil
+}
+
+// relayoutVideoRendition updates the path of the video file that a
+// VideoRendition points to as well as moving it.
+
+func relayoutVideoRendition(source storage.Store, target storage.Store, layout Layout, ph *photo.FlickrPhoto, key string, new_key string) (*ManifestFile, error) {
+
+	body, err := util.ReadKey(source, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var rendition VideoRendition
+
+	err = json.Unmarshal(body, &rendition)
+
+	if err != nil {
+		msg := fmt.Sprintf("Failed to parse %s: %s", key, err)
+		return nil, errors.New(msg)
+	}
+
+	rendition.Path, err = layout.Path(ph, path.Base(rendition.Path))
+
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := json.Marshal(rendition)
+
+	if err != nil {
+		return nil, err
+	}
+
+	checksum, err := util.PutKey(target, new_key, enc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f := ManifestFile{
+		Name:   path.Base(new_key),
+		Size:   int64(len(enc)),
+		SHA256: checksum,
+	}
+
+	return &f, nil
+}
+
+// updateManifests replaces the entry for f in each of manifests, which are
+// encoded Manifests keyed by where they are.
+
+func updateManifests(manifests map[string][]byte, f *ManifestFile) error {
+
+	for key, body := range manifests {
+
+		var m Manifest
+
+		err := json.Unmarshal(body, &m)
+
+		if err != nil {
+			msg := fmt.Sprintf("Failed to parse %s: %s", key, err)
+			return errors.New(msg)
+		}
+
+		m.Add(f)
+
+		enc, err := json.Marshal(m)
+
+		if err != nil {
+			return err
+		}
+
+		manifests[key] = enc
+	}
+
+	return nil
+}
+
+func copyKey(source storage.Store, target storage.Store, key string, new_key string) error {
+
+	fh, err := source.Get(key)
+
+	if err != nil {
+		return err
+	}
+
+	defer fh.Close()
+
+	return util.Replace(target, new_key, fh)
+}
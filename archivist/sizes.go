@@ -0,0 +1,132 @@
+package archivist
+
+// https://www.flickr.com/services/api/flickr.photos.getSizes.html
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tidwall/gjson"
+)
+
+// archive the first size in the list that exists
+
+const SIZES_MODE_FIRST string = "first"
+
+// archive every size in the list that exists
+
+const SIZES_MODE_ALL string = "all"
+
+type SizePolicy struct {
+	Mode   string
+	Labels []string
+}
+
+func DefaultSizePolicy() *SizePolicy {
+
+	labels := []string{
+		"Original",
+		"Large 2048",
+		"Large 1600",
+		"Large",
+		"Medium 800",
+		"Medium 640",
+		"Medium",
+	}
+
+	p := SizePolicy{
+		Mode:   SIZES_MODE_FIRST,
+		Labels: labels,
+	}
+
+	return &p
+}
+
+func NewSizePolicy(mode string, labels ...string) (*SizePolicy, error) {
+
+	switch mode {
+	case SIZES_MODE_FIRST, SIZES_MODE_ALL:
+		// pass
+	default:
+		msg := fmt.Sprintf("Invalid sizes mode '%s'", mode)
+		return nil, errors.New(msg)
+	}
+
+	if len(labels) == 0 {
+		return nil, errors.New("Missing size labels")
+	}
+
+	p := SizePolicy{
+		Mode:   mode,
+		Labels: labels,
+	}
+
+	return &p, nil
+}
+
+// Select returns the entries in a flickr.photos.getSizes response to
+// archive, in the order they are listed in the policy.
+
+func (p *SizePolicy) Select(sizes []byte) []gjson.Result {
+
+	selected := make([]gjson.Result, 0)
+
+	for _, label := range p.Labels {
+
+		rsp, ok := findSize(sizes, label)
+
+		if !ok {
+			continue
+		}
+
+		selected = append(selected, rsp)
+
+		if p.Mode == SIZES_MODE_FIRST {
+			break
+		}
+	}
+
+	return selected
+}
+
+// SelectFromURLs is Select for the url_ extras included in a search result,
+// keyed by size label. The second return value is false if it's not possible
+// to know which sizes to archive without calling flickr.photos.getSizes,
+// for example because the url_ extra for a label wasn't requested.
+
+func (p *SizePolicy) SelectFromURLs(urls map[string]string) ([]string, bool) {
+
+	selected := make([]string, 0)
+
+	for _, label := range p.Labels {
+
+		u, ok := urls[label]
+
+		if !ok {
+			return nil, false
+		}
+
+		if u == "" {
+			continue
+		}
+
+		selected = append(selected, u)
+
+		if p.Mode == SIZES_MODE_FIRST {
+			break
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, false
+	}
+
+	return selected, true
+}
+
+func findSize(sizes []byte, label string) (gjson.Result, bool) {
+
+	path := fmt.Sprintf(`sizes.size.#[label="%s"]`, label)
+	rsp := gjson.GetBytes(sizes, path)
+
+	return rsp, rsp.Exists()
+}
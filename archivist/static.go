@@ -3,20 +3,26 @@ package archivist
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/aaronland/go-flickr-archive"
 	"github.com/aaronland/go-flickr-archive/flickr"
+	"github.com/aaronland/go-flickr-archive/journal"
 	"github.com/aaronland/go-flickr-archive/photo"
+	"github.com/aaronland/go-flickr-archive/throttle"
+	"github.com/aaronland/go-flickr-archive/util"
 	"github.com/aaronland/go-storage"
-	"github.com/tidwall/gjson"
+	"io"
 	"io/ioutil"
-	_ "log"
+	"log"
 	"net/http"
 	"net/url"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,9 +32,18 @@ type StaticArchivistOptions struct {
 	ArchiveEXIF       bool
 	ArchiveComments   bool
 	ArchiveRequest    bool
+	ContinueOnError   bool
+	Incremental       bool
+	Journal           journal.Journal
 	RequestsPerSecond int
+	Concurrency       int
+	Sizes             *SizePolicy
+	Layout            Layout
+	Retry             *flickr.RetryPolicy
+	// if nil a throttle allowing RequestsPerSecond downloads is created;
+	// pass the same throttle as the API's to share a single limit
+	Throttle throttle.Throttle
 	// Logger
-	// Throttle
 }
 
 func DefaultStaticArchivistOptions() (*StaticArchivistOptions, error) {
@@ -39,7 +54,13 @@ func DefaultStaticArchivistOptions() (*StaticArchivistOptions, error) {
 		ArchiveEXIF:       false,
 		ArchiveComments:   false,
 		ArchiveRequest:    false,
+		ContinueOnError:   false,
+		Incremental:       false,
 		RequestsPerSecond: 10,
+		Concurrency:       10,
+		Sizes:             DefaultSizePolicy(),
+		Layout:            DefaultLayout(),
+		Retry:             flickr.DefaultRetryPolicy(),
 	}
 
 	return &opts, nil
@@ -49,16 +70,24 @@ type StaticArchivist struct {
 	archive.Archivist
 	store    storage.Store
 	options  *StaticArchivistOptions
-	throttle <-chan time.Time
+	throttle throttle.Throttle
 	client   *http.Client
 }
 
 func NewStaticArchivist(store storage.Store, opts *StaticArchivistOptions) (archive.Archivist, error) {
 
-	// https://github.com/golang/go/wiki/RateLimiting
+	t := opts.Throttle
 
-	rate := time.Second / time.Duration(opts.RequestsPerSecond)
-	throttle := time.Tick(rate)
+	if t == nil {
+
+		bucket, err := throttle.NewTokenBucket(float64(opts.RequestsPerSecond), 1)
+
+		if err != nil {
+			return nil, err
+		}
+
+		t = bucket
+	}
 
 	// maybe make this an option?
 
@@ -69,10 +98,29 @@ func NewStaticArchivist(store storage.Store, opts *StaticArchivistOptions) (arch
 
 	client := &http.Client{Transport: tr}
 
+	if opts.Layout == nil {
+		opts.Layout = DefaultLayout()
+	}
+
+	if opts.Sizes == nil {
+		opts.Sizes = DefaultSizePolicy()
+	}
+
+	if opts.ContinueOnError && opts.Journal == nil {
+
+		j, err := journal.NewStoreJournal(store)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts.Journal = j
+	}
+
 	arch := StaticArchivist{
 		store:    store,
 		options:  opts,
-		throttle: throttle,
+		throttle: t,
 		client:   client,
 	}
 
@@ -80,158 +128,392 @@ func NewStaticArchivist(store storage.Store, opts *StaticArchivistOptions) (arch
 }
 
 func (arch *StaticArchivist) ArchivePhotos(api flickr.API, photos ...photo.Photo) error {
+	return arch.ArchivePhotosWithContext(context.Background(), api, photos...)
+}
+
+func (arch *StaticArchivist) ArchivePhotosWithContext(ctx context.Context, api flickr.API, photos ...photo.Photo) error {
+
+	if len(photos) == 0 {
+		return nil
+	}
 
-	done_ch := make(chan bool)
-	err_ch := make(chan error)
+	parent_ctx := ctx
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	for _, ph := range photos {
+	workers := arch.options.Concurrency
+
+	if workers < 1 {
+		workers = 1
+	}
 
-		go func(ctx context.Context, ph photo.Photo, done_ch chan bool, err_ch chan error) {
+	if workers > len(photos) {
+		workers = len(photos)
+	}
 
-			defer func() {
-				done_ch <- true
-			}()
+	photos_ch := make(chan photo.Photo)
 
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				// pass
-			}
+	// buffered so that workers never block reporting errors, even if
+	// every single photo fails
 
-			err := arch.ArchivePhoto(ctx, api, ph)
+	err_ch := make(chan error, len(photos))
 
-			if err != nil {
-				err_ch <- err
-			}
+	wg := new(sync.WaitGroup)
 
-		}(ctx, ph, done_ch, err_ch)
-	}
+	for i := 0; i < workers; i++ {
 
-	remaining := len(photos)
+		wg.Add(1)
 
-	for remaining > 0 {
+		go func() {
+
+			defer wg.Done()
+
+			for ph := range photos_ch {
+
+				err := arch.archivePhoto(ctx, api, ph)
+
+				if err != nil {
+					err_ch <- err
+					cancel()
+				}
+			}
+		}()
+	}
+
+enqueue:
+	for _, ph := range photos {
 
 		select {
-		case <-done_ch:
-			remaining -= 1
-		case e := <-err_ch:
-			return e
-		default:
+		case <-ctx.Done():
+			break enqueue
+		case photos_ch <- ph:
 			// pass
 		}
 	}
 
-	return nil
+	close(photos_ch)
+
+	wg.Wait()
+	close(err_ch)
+
+	errs := make([]error, 0)
+
+	for err := range err_ch {
+
+		// photos that were interrupted because some other photo failed
+		// (or because we were told to stop) aren't interesting
+
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			continue
+		}
+
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 0 {
+		return parent_ctx.Err()
+	}
+
+	return errors.Join(errs...)
+}
+
+// archivePhoto is ArchivePhoto but in continue-on-error mode failures are
+// written to the journal instead of being returned
+
+func (arch *StaticArchivist) archivePhoto(ctx context.Context, api flickr.API, ph photo.Photo) error {
+
+	err := arch.ArchivePhoto(ctx, api, ph)
+
+	if err == nil || !arch.options.ContinueOnError || ctx.Err() != nil {
+		return err
+	}
+
+	log.Printf("Failed to archive photo %d, recording in journal: %s\n", ph.Id(), err)
+
+	return arch.options.Journal.Record(journal.NewEntry(ph.Id(), err))
 }
 
 func (arch *StaticArchivist) ArchivePhoto(ctx context.Context, api flickr.API, ph photo.Photo) error {
 
 	select {
 	case <-ctx.Done():
-		return nil
+		return ctx.Err()
 	default:
 		// pass
 	}
 
-	<-arch.throttle
-
 	str_id := strconv.FormatInt(ph.Id(), 10)
 
-	info_params := url.Values{}
-	info_params.Set("photo_id", str_id)
+	// if ph came from a search that included flickr.ARCHIVE_EXTRAS then
+	// we don't need to call flickr.photos.getInfo unless we're archiving
+	// the response
+
+	var fph *photo.FlickrPhoto
+	has_extras := false
+
+	if p, ok := ph.(*photo.FlickrPhoto); ok && p.HasArchiveExtras() {
+		fph = p
+		has_extras = true
+	}
+
+	var info []byte
+
+	if has_extras && arch.options.Incremental {
+
+		// if the layout needs something the search didn't include then
+		// wait until we've called flickr.photos.getInfo
+
+		manifest_path, err := arch.path(fph, ManifestFilename(fph))
+
+		if err == nil {
+
+			changed, err := arch.hasChanged(manifest_path, fph.LastUpdate)
 
-	info, info_err := api.ExecuteMethod("flickr.photos.getInfo", info_params)
+			if err != nil {
+				return err
+			}
 
-	if info_err != nil {
-		return info_err
+			if !changed {
+				return nil
+			}
+		}
 	}
 
-	secret_rsp := gjson.GetBytes(info, "photo.originalsecret")
+	if !has_extras || arch.options.ArchiveInfo {
+
+		info_params := url.Values{}
+		info_params.Set("photo_id", str_id)
+
+		info_rsp, info_err := api.ExecuteMethodWithContext(ctx, "flickr.photos.getInfo", info_params)
+
+		// photos get deleted in between being listed in a search result and
+		// being archived - that's not a reason to stop everything else
+
+		if errors.Is(info_err, flickr.ErrPhotoNotFound) {
+			log.Printf("Photo %s not found, skipping\n", str_id)
+			return nil
+		}
+
+		if info_err != nil {
+			return info_err
+		}
+
+		info_ph, err := photo.NewFlickrPhotoFromInfo(info_rsp)
+
+		if err != nil {
+			return err
+		}
+
+		// keep any url_ extras from the search result
+
+		if has_extras {
+			info_ph.URLs = fph.URLs
+		}
 
-	if !secret_rsp.Exists() {
-		secret_rsp = gjson.GetBytes(info, "photo.secret")
+		fph = info_ph
+		info = info_rsp
 	}
 
-	if !secret_rsp.Exists() {
-		return errors.New("Unable to determine photo secret")
+	secret := fph.ArchiveSecret()
+	media := fph.Media
+
+	info_path, err := arch.path(fph, fmt.Sprintf("%s_%s_i.json", str_id, secret))
+
+	if err != nil {
+		return err
 	}
 
-	secret := secret_rsp.String()
+	if !has_extras && arch.options.Incremental {
+
+		manifest_path, err := arch.path(fph, ManifestFilename(fph))
 
-	sizes_params := url.Values{}
-	sizes_params.Set("photo_id", str_id)
+		if err != nil {
+			return err
+		}
 
-	sizes, sizes_err := api.ExecuteMethod("flickr.photos.getSizes", sizes_params)
+		changed, err := arch.hasChanged(manifest_path, fph.LastUpdate)
+
+		if err != nil {
+			return err
+		}
 
-	if sizes_err != nil {
-		return sizes_err
+		if !changed {
+			return nil
+		}
 	}
 
-	photo_url := ""
+	var photo_urls []string
+	var sizes []byte
 
-	possible_sizes := []string{
-		"Original",
-		"Large 2048",
-		"Large 1600",
-		"Large",
-		"Medium 800",
-		"Medium 640",
-		"Medium",
+	if has_extras && !arch.options.ArchiveSizes && media != "video" {
+		photo_urls, _ = arch.options.Sizes.SelectFromURLs(fph.URLs)
 	}
 
-	for _, label := range possible_sizes {
+	if len(photo_urls) == 0 {
 
-		path := fmt.Sprintf(`sizes.size.#[label="%s"]`, label)
-		// log.Println("TRY", path)
+		sizes_params := url.Values{}
+		sizes_params.Set("photo_id", str_id)
 
-		rsp := gjson.GetBytes(sizes, path)
+		sizes_rsp, sizes_err := api.ExecuteMethodWithContext(ctx, "flickr.photos.getSizes", sizes_params)
 
-		if !rsp.Exists() {
-			continue
+		if errors.Is(sizes_err, flickr.ErrPhotoNotFound) {
+			log.Printf("Photo %s not found, skipping\n", str_id)
+			return nil
+		}
+
+		if sizes_err != nil {
+			return sizes_err
 		}
 
-		src := rsp.Get("source")
-		photo_url = src.String()
-		break
+		sizes = sizes_rsp
+
+		for _, rsp := range arch.options.Sizes.Select(sizes) {
+			photo_urls = append(photo_urls, rsp.Get("source").String())
+		}
 	}
 
-	if photo_url == "" {
+	if len(photo_urls) == 0 {
 		return errors.New("Unable to determine photo URL")
 	}
 
-	img_rsp, err := arch.client.Get(photo_url)
+	// whatever was archived for this photo before stops counting until
+	// everything has been written again, see commit
+
+	m, err := arch.begin(fph)
 
 	if err != nil {
 		return err
 	}
 
-	defer img_rsp.Body.Close()
+	// for videos these are poster frames
 
-	img_fname := filepath.Base(photo_url)
-	img_path := fmt.Sprintf("%s/%s", str_id, img_fname)
+	for _, photo_url := range photo_urls {
 
-	err = arch.store.Put(img_path, img_rsp.Body)
+		if photo_url == "" {
+			return errors.New("Unable to determine photo URL")
+		}
 
-	if err != nil {
-		return err
+		img_path, err := arch.path(fph, filepath.Base(photo_url))
+
+		if err != nil {
+			return err
+		}
+
+		var f *ManifestFile
+
+		err = arch.options.Retry.Do(ctx, func() error {
+
+			downloaded, err := arch.download(ctx, photo_url, img_path)
+
+			if err != nil {
+				return err
+			}
+
+			f = downloaded
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		m.Add(f)
+	}
+
+	if media == "video" {
+
+		err := arch.archiveVideo(ctx, fph, sizes, m)
+
+		if err != nil {
+			return err
+		}
 	}
 
 	if arch.options.ArchiveInfo {
 
-		info_path := fmt.Sprintf("%s/%s_%s_i.json", str_id, str_id, secret)
+		f, err := arch.putBytes(info_path, info)
 
-		info_r := bytes.NewReader(info)
-		info_fh := ioutil.NopCloser(info_r)
+		if err != nil {
+			return err
+		}
+
+		m.Add(f)
+	}
+
+	if arch.options.ArchiveSizes {
+
+		sizes_path, err := arch.path(fph, fmt.Sprintf("%s_%s_s.json", str_id, secret))
+
+		if err != nil {
+			return err
+		}
 
-		err = arch.store.Put(info_path, info_fh)
+		f, err := arch.putBytes(sizes_path, sizes)
 
 		if err != nil {
 			return err
 		}
+
+		m.Add(f)
+	}
+
+	if arch.options.ArchiveEXIF {
+
+		exif_params := url.Values{}
+		exif_params.Set("photo_id", str_id)
+
+		exif, err := api.ExecuteMethodWithContext(ctx, "flickr.photos.getExif", exif_params)
+
+		// people can choose to hide EXIF data from everyone else which
+		// shouldn't stop the photo itself from being archived
+
+		if errors.Is(err, flickr.ErrPermissionDenied) {
+			log.Printf("EXIF data for photo %s is not public, skipping\n", str_id)
+		} else if err != nil {
+			return err
+		} else {
+
+			exif_path, err := arch.path(fph, fmt.Sprintf("%s_%s_e.json", str_id, secret))
+
+			if err != nil {
+				return err
+			}
+
+			f, err := arch.putBytes(exif_path, exif)
+
+			if err != nil {
+				return err
+			}
+
+			m.Add(f)
+		}
+	}
+
+	if arch.options.ArchiveComments {
+
+		comments_params := url.Values{}
+		comments_params.Set("photo_id", str_id)
+
+		comments, err := api.ExecuteMethodWithContext(ctx, "flickr.photos.comments.getList", comments_params)
+
+		if err != nil {
+			return err
+		}
+
+		comments_path, err := arch.path(fph, fmt.Sprintf("%s_%s_c.json", str_id, secret))
+
+		if err != nil {
+			return err
+		}
+
+		f, err := arch.putBytes(comments_path, comments)
+
+		if err != nil {
+			return err
+		}
+
+		m.Add(f)
 	}
 
 	if arch.options.ArchiveRequest {
@@ -242,19 +524,248 @@ func (arch *StaticArchivist) ArchivePhoto(ctx context.Context, api flickr.API, p
 			return nil
 		}
 
-		ph_r := bytes.NewReader(enc_ph)
-		ph_fh := ioutil.NopCloser(ph_r)
-
 		// should this have a secret? (20181127/thisisaaronland)
 
-		ph_path := fmt.Sprintf("%s/%s_r.json", str_id, str_id)
+		ph_path, err := arch.path(fph, fmt.Sprintf("%s_r.json", str_id))
+
+		if err != nil {
+			return err
+		}
+
+		f, err := arch.putBytes(ph_path, enc_ph)
+
+		if err != nil {
+			return err
+		}
+
+		m.Add(f)
+	}
+
+	return arch.commit(fph, m)
+}
+
+// VideoRendition records which video file was saved for a video, since
+// there are lots of possible renditions and not all of them exist for every
+// video.
+
+type VideoRendition struct {
+	Label  string `json:"label"`
+	Source string `json:"source"`
+	Width  int64  `json:"width"`
+	Height int64  `json:"height"`
+	Path   string `json:"path"`
+}
+
+func (arch *StaticArchivist) archiveVideo(ctx context.Context, fph *photo.FlickrPhoto, sizes []byte, m *Manifest) error {
+
+	str_id := strconv.FormatInt(fph.ID, 10)
+	secret := fph.ArchiveSecret()
+	original_format := fph.OriginalFormat
+
+	// in order of preference
+
+	possible_sizes := []string{
+		"Video Original",
+		"1080p",
+		"HD MP4",
+		"720p",
+		"Site MP4",
+		"360p",
+		"Mobile MP4",
+	}
+
+	for _, label := range possible_sizes {
+
+		rsp, ok := findSize(sizes, label)
+
+		if !ok {
+			continue
+		}
+
+		video_url := rsp.Get("source").String()
+
+		if video_url == "" {
+			continue
+		}
+
+		// video URLs look like https://www.flickr.com/photos/{user}/{id}/play/{label}/{secret}/
+		// so there's no filename to speak of
+
+		ext := "mp4"
+
+		if label == "Video Original" && original_format != "" {
+			ext = original_format
+		}
+
+		slug := strings.ToLower(strings.Replace(label, " ", "-", -1))
+
+		video_fname := fmt.Sprintf("%s_%s_%s.%s", str_id, secret, slug, ext)
+		video_path, err := arch.path(fph, video_fname)
+
+		if err != nil {
+			return err
+		}
+
+		var f *ManifestFile
+
+		err = arch.options.Retry.Do(ctx, func() error {
+
+			downloaded, err := arch.download(ctx, video_url, video_path)
+
+			if err != nil {
+				return err
+			}
 
-		err = arch.store.Put(ph_path, ph_fh)
+			f = downloaded
+			return nil
+		})
 
 		if err != nil {
 			return err
 		}
+
+		m.Add(f)
+
+		rendition := VideoRendition{
+			Label:  label,
+			Source: video_url,
+			Width:  rsp.Get("width").Int(),
+			Height: rsp.Get("height").Int(),
+			Path:   video_path,
+		}
+
+		enc_rendition, err := json.Marshal(rendition)
+
+		if err != nil {
+			return err
+		}
+
+		rendition_path, err := arch.path(fph, fmt.Sprintf("%s_%s_v.json", str_id, secret))
+
+		if err != nil {
+			return err
+		}
+
+		rendition_f, err := arch.putBytes(rendition_path, enc_rendition)
+
+		if err != nil {
+			return err
+		}
+
+		m.Add(rendition_f)
+		return nil
+	}
+
+	return errors.New("Unable to determine video URL")
+}
+
+// path returns the key for fname, one of the files for fph, according to
+// the archivist's layout.
+
+func (arch *StaticArchivist) path(fph *photo.FlickrPhoto, fname string) (string, error) {
+	return arch.options.Layout.Path(fph, fname)
+}
+
+func (arch *StaticArchivist) download(ctx context.Context, remote string, path string) (*ManifestFile, error) {
+
+	// API calls are throttled by the API itself
+
+	err := arch.throttle.Wait(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", remote, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, err := arch.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != 200 {
+		return nil, flickr.NewHTTPError(rsp)
+	}
+
+	f, err := arch.put(path, rsp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.ContentLength >= 0 && f.Size != rsp.ContentLength {
+		msg := fmt.Sprintf("Download of %s was truncated, expected %d bytes but got %d", remote, rsp.ContentLength, f.Size)
+		return nil, errors.New(msg)
+	}
+
+	return f, nil
+}
+
+// put replaces path with fh (see util.Replace) and returns the manifest
+// entry for path, worked out as it's written.
+
+func (arch *StaticArchivist) put(path string, fh io.ReadCloser) (*ManifestFile, error) {
+
+	hr := util.NewHashingReader(fh, sha256.New())
+
+	err := util.Replace(arch.store, path, hr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return manifestFile(path, hr), nil
+}
+
+func (arch *StaticArchivist) putBytes(path string, body []byte) (*ManifestFile, error) {
+
+	r := bytes.NewReader(body)
+	fh := ioutil.NopCloser(r)
+
+	return arch.put(path, fh)
+}
+
+// hasChanged compares lastupdate (from flickr.photos.getInfo or the
+// last_update extra) with the lastupdate date recorded in the manifest for a
+// previously archived copy of the photo. Photos without a manifest were never
+// completely archived so they have always changed.
+
+func (arch *StaticArchivist) hasChanged(manifest_path string, lastupdate int64) (bool, error) {
+
+	if lastupdate == 0 {
+		return true, nil
+	}
+
+	exists, err := arch.store.Exists(manifest_path)
+
+	if err != nil {
+		return false, err
+	}
+
+	if !exists {
+		return true, nil
+	}
+
+	body, err := util.ReadKey(arch.store, manifest_path)
+
+	if err != nil {
+		return false, err
+	}
+
+	var m Manifest
+
+	err = json.Unmarshal(body, &m)
+
+	if err != nil || m.LastUpdate == 0 {
+		return true, nil
 	}
 
-	return nil
+	return m.LastUpdate != lastupdate, nil
 }
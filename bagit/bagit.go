@@ -0,0 +1,484 @@
+package bagit
+
+// a BagIt (RFC 8493) writer and validator on top of storage.Store, so that
+// archives can be handed over to people who ingest bags rather than loose
+// directories:
+//
+// bag, err := bagit.NewBag(store, info)
+// arch, err := archivist.NewStaticArchivist(bag, opts)
+// ...
+// err = bag.Close()
+//
+// Anything written to a Bag goes in the payload (data/) directory and is
+// checksummed as it's written, except bookkeeping keys starting with an
+// underscore (the journal, checkpoints) which go alongside the payload and
+// aren't part of the bag proper. The tag files are written when the bag is
+// flushed or closed, or only when it's closed if it's being written to a tar
+// or zip archive since those can't replace anything that's already in them.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-flickr-archive/stores"
+	"github.com/aaronland/go-flickr-archive/util"
+	"github.com/aaronland/go-storage"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const BAGIT_VERSION string = "1.0"
+
+const PAYLOAD_DIR string = "data"
+
+const BAGIT_TXT string = "bagit.txt"
+
+const BAG_INFO_TXT string = "bag-info.txt"
+
+const SOFTWARE_AGENT string = "go-flickr-archive (https://github.com/aaronland/go-flickr-archive)"
+
+// Bag is a storage.Store that writes a bag.
+
+type Bag interface {
+	storage.Store
+	// Flush (re)writes the tag files so that the bag is valid as of now.
+	// It does nothing once the bag has been closed or if the bag is being
+	// written to a write-only store (see stores.IsWriteOnlyStore).
+	Flush() error
+	// Close flushes the bag and closes the store it is written to.
+	Close() error
+}
+
+type payloadFile struct {
+	checksum string
+	size     int64
+}
+
+type storeBag struct {
+	Bag
+	store    storage.Store
+	info     BagInfo
+	payload  map[string]*payloadFile
+	closed   bool
+	mu       *sync.Mutex
+	flush_mu *sync.Mutex
+}
+
+type bagFile struct {
+	io.WriteCloser
+	bag *storeBag
+	key string
+	buf *bytes.Buffer
+}
+
+func (f *bagFile) Write(b []byte) (int, error) {
+	return f.buf.Write(b)
+}
+
+func (f *bagFile) Close() error {
+	return f.bag.Put(f.key, ioutil.NopCloser(f.buf))
+}
+
+func ManifestName(alg string) string {
+	return fmt.Sprintf("manifest-%s.txt", alg)
+}
+
+func TagManifestName(alg string) string {
+	return fmt.Sprintf("tagmanifest-%s.txt", alg)
+}
+
+// NewBag returns a Bag written to store, with info (plus the fields that the
+// bag fills in itself) as its bag-info.txt. If store already has a bag in
+// it, from an earlier run say, then it is added to rather than replaced.
+
+func NewBag(store storage.Store, info BagInfo) (Bag, error) {
+
+	b := storeBag{
+		store:    store,
+		info:     NewBagInfo(),
+		payload:  make(map[string]*payloadFile),
+		mu:       new(sync.Mutex),
+		flush_mu: new(sync.Mutex),
+	}
+
+	err := b.load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	b.info.Merge(info)
+
+	return &b, nil
+}
+
+// load reads the tag files of an existing bag and reconciles them with the
+// payload that's actually there, since a bag that was being written when the
+// process was killed won't have been flushed.
+
+func (b *storeBag) load() error {
+
+	exists, err := b.store.Exists(BAG_INFO_TXT)
+
+	if err != nil {
+		return err
+	}
+
+	if exists {
+
+		body, err := util.ReadKey(b.store, BAG_INFO_TXT)
+
+		if err != nil {
+			return err
+		}
+
+		info, err := ParseBagInfo(body)
+
+		if err != nil {
+			return err
+		}
+
+		b.info = info
+	}
+
+	checksums := make(map[string]string)
+
+	manifest_name := ManifestName(ALGORITHM_SHA256)
+
+	exists, err = b.store.Exists(manifest_name)
+
+	if err != nil {
+		return err
+	}
+
+	if exists {
+
+		body, err := util.ReadKey(b.store, manifest_name)
+
+		if err != nil {
+			return err
+		}
+
+		entries, err := ParseManifest(body)
+
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			checksums[e.Path] = e.Checksum
+		}
+	}
+
+	cb := func(p string, args ...interface{}) error {
+
+		bag_path := util.StoreKey(b.store, p)
+
+		if !isPayloadPath(bag_path) {
+			return nil
+		}
+
+		k := strings.TrimPrefix(bag_path, PAYLOAD_DIR+"/")
+
+		checksum, ok := checksums[bag_path]
+
+		// filesystem and S3 stores tell us the size for free (from
+		// the directory or the bucket listing), otherwise files have
+		// to be read
+
+		if ok && len(args) > 0 {
+
+			info, is_info := args[0].(os.FileInfo)
+
+			if is_info {
+				b.payload[k] = &payloadFile{checksum: checksum, size: info.Size()}
+				return nil
+			}
+		}
+
+		f, err := hashKey(b.store, bag_path)
+
+		if err != nil {
+			return err
+		}
+
+		// a checksum that doesn't match is for Validate to find, not
+		// for us to paper over
+
+		if ok {
+			f.checksum = checksum
+		}
+
+		b.payload[k] = f
+		return nil
+	}
+
+	return b.store.Walk(cb)
+}
+
+// URI returns a URI for k relative to the bag, rather than to where it is
+// actually stored in it, so that util.StoreKey works.
+
+func (b *storeBag) URI(k string) string {
+	return strings.TrimRight(b.store.URI(""), "/") + "/" + k
+}
+
+func (b *storeBag) Get(k string) (io.ReadCloser, error) {
+	return b.store.Get(b.bagPath(k))
+}
+
+func (b *storeBag) Exists(k string) (bool, error) {
+	return b.store.Exists(b.bagPath(k))
+}
+
+func (b *storeBag) Open(k string) (io.WriteCloser, error) {
+
+	f := bagFile{
+		bag: b,
+		key: k,
+		buf: new(bytes.Buffer),
+	}
+
+	return &f, nil
+}
+
+func (b *storeBag) Put(k string, in io.ReadCloser) error {
+
+	k = strings.TrimLeft(k, "/")
+
+	if isBookkeeping(k) {
+		return util.Replace(b.store, k, in)
+	}
+
+	err := b.checkClosed()
+
+	if err != nil {
+		return err
+	}
+
+	hr := util.NewHashingReader(in, sha256.New())
+
+	err = util.Replace(b.store, b.bagPath(k), hr)
+
+	if err != nil {
+		return err
+	}
+
+	f := payloadFile{
+		checksum: hr.Checksum(),
+		size:     hr.Size(),
+	}
+
+	b.mu.Lock()
+	b.payload[k] = &f
+	b.mu.Unlock()
+
+	return nil
+}
+
+func (b *storeBag) Delete(k string) error {
+
+	k = strings.TrimLeft(k, "/")
+
+	err := b.store.Delete(b.bagPath(k))
+
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	delete(b.payload, k)
+	b.mu.Unlock()
+
+	return nil
+}
+
+// Walk walks the payload and bookkeeping files, but not the tag files.
+
+func (b *storeBag) Walk(cb storage.WalkFunc) error {
+
+	walk_cb := func(p string, args ...interface{}) error {
+
+		bag_path := util.StoreKey(b.store, p)
+
+		if isPayloadPath(bag_path) {
+			return cb(b.URI(strings.TrimPrefix(bag_path, PAYLOAD_DIR+"/")), args...)
+		}
+
+		if isBookkeeping(bag_path) {
+			return cb(b.URI(bag_path), args...)
+		}
+
+		return nil
+	}
+
+	return b.store.Walk(walk_cb)
+}
+
+func (b *storeBag) Flush() error {
+
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+
+	if closed {
+		return nil
+	}
+
+	// every flush would add another copy of each tag file to the archive
+
+	if stores.IsWriteOnlyStore(b.store) {
+		return nil
+	}
+
+	return b.flush()
+}
+
+func (b *storeBag) flush() error {
+
+	b.flush_mu.Lock()
+	defer b.flush_mu.Unlock()
+
+	b.mu.Lock()
+
+	checksums := make(map[string]string)
+	octets := int64(0)
+
+	for k, f := range b.payload {
+		checksums[path.Join(PAYLOAD_DIR, k)] = f.checksum
+		octets += f.size
+	}
+
+	info := NewBagInfo()
+	info.Merge(b.info)
+
+	info.Set(INFO_BAGGING_DATE, time.Now().Format("2006-01-02"))
+	info.Set(INFO_PAYLOAD_OXUM, fmt.Sprintf("%d.%d", octets, len(b.payload)))
+
+	_, ok := info.Get(INFO_SOFTWARE_AGENT)
+
+	if !ok {
+		info.Set(INFO_SOFTWARE_AGENT, SOFTWARE_AGENT)
+	}
+
+	b.mu.Unlock()
+
+	bagit_txt := []byte(fmt.Sprintf("BagIt-Version: %s\nTag-File-Character-Encoding: UTF-8\n", BAGIT_VERSION))
+
+	tag_files := []struct {
+		name string
+		body []byte
+	}{
+		{BAGIT_TXT, bagit_txt},
+		{BAG_INFO_TXT, info.Bytes()},
+		{ManifestName(ALGORITHM_SHA256), manifestBytes(checksums)},
+	}
+
+	tag_checksums := make(map[string]string)
+
+	for _, t := range tag_files {
+
+		checksum, err := util.PutKey(b.store, t.name, t.body)
+
+		if err != nil {
+			return err
+		}
+
+		tag_checksums[t.name] = checksum
+	}
+
+	_, err := util.PutKey(b.store, TagManifestName(ALGORITHM_SHA256), manifestBytes(tag_checksums))
+	return err
+}
+
+// Close flushes the bag and closes the underlying store. It is safe to call
+// more than once.
+
+func (b *storeBag) Close() error {
+
+	b.mu.Lock()
+
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+
+	b.closed = true
+	b.mu.Unlock()
+
+	// waits for any Flush that's already going
+
+	err := b.flush()
+
+	close_err := stores.Close(b.store)
+
+	if err != nil {
+		return err
+	}
+
+	return close_err
+}
+
+func (b *storeBag) checkClosed() error {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return errors.New("Bag has already been closed")
+	}
+
+	return nil
+}
+
+// bagPath returns where k is in the bag.
+
+func (b *storeBag) bagPath(k string) string {
+
+	k = strings.TrimLeft(k, "/")
+
+	if isBookkeeping(k) {
+		return k
+	}
+
+	return path.Join(PAYLOAD_DIR, k)
+}
+
+func isBookkeeping(k string) bool {
+	return strings.HasPrefix(k, "_")
+}
+
+func isPayloadPath(p string) bool {
+	return strings.HasPrefix(p, PAYLOAD_DIR+"/")
+}
+
+func hashKey(store storage.Store, key string) (*payloadFile, error) {
+
+	fh, err := store.Get(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	hr := util.NewHashingReader(fh, sha256.New())
+	defer hr.Close()
+
+	_, err = io.Copy(ioutil.Discard, hr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f := payloadFile{
+		checksum: hr.Checksum(),
+		size:     hr.Size(),
+	}
+
+	return &f, nil
+}
@@ -0,0 +1,198 @@
+package bagit
+
+import (
+	"bytes"
+	"context"
+	"github.com/aaronland/go-flickr-archive/stores"
+	"github.com/aaronland/go-flickr-archive/util"
+	"github.com/aaronland/go-storage"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func putKey(t *testing.T, store storage.Store, k string, body []byte) {
+
+	err := store.Put(k, ioutil.NopCloser(bytes.NewReader(body)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBagRoundTrip(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		open func(*testing.T) func() storage.Store
+	}{
+		{"fs", func(t *testing.T) func() storage.Store {
+
+			root := t.TempDir()
+
+			return func() storage.Store {
+
+				store, err := stores.NewStore(root)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return store
+			}
+		}},
+		{"mem", func(t *testing.T) func() storage.Store {
+
+			store, err := stores.NewStore("mem://")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// there is nothing to reopen, so hand back the same store
+
+			return func() storage.Store {
+				return store
+			}
+		}},
+	}
+
+	// '%' and newlines have to be encoded in manifests
+
+	odd_key := "123/100%\nreal.jpg"
+	odd_path := "data/123/100%25%0Areal.jpg"
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			open := test.open(t)
+
+			info := NewBagInfo()
+			info.Add("Source-Organization", "example")
+
+			bag, err := NewBag(open(), info)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			putKey(t, bag, "123/123_abc_o.jpg", []byte("jpeg"))
+			putKey(t, bag, "123/123_abc_i.json", []byte(`{"photo":{}}`))
+			putKey(t, bag, odd_key, []byte("odd"))
+			putKey(t, bag, "_journal/123.json", []byte(`{"id":123}`))
+
+			err = bag.Close()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			store := open()
+
+			err = Validate(ctx, store)
+
+			if err != nil {
+				t.Fatalf("Expected a valid bag but got %s", err)
+			}
+
+			manifest, err := util.ReadKey(store, ManifestName(ALGORITHM_SHA256))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(string(manifest), "  "+odd_path+"\n") {
+				t.Errorf("Expected manifest to list %s but got %q", odd_path, manifest)
+			}
+
+			if bytes.Contains(manifest, []byte("_journal")) {
+				t.Errorf("Expected bookkeeping to be left out of the manifest but got %q", manifest)
+			}
+
+			// reopening adds to the bag rather than replacing it
+
+			bag, err = NewBag(store, NewBagInfo())
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			putKey(t, bag, "456/456_def_o.jpg", []byte("another jpeg"))
+
+			err = bag.Close()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			store = open()
+
+			err = Validate(ctx, store)
+
+			if err != nil {
+				t.Fatalf("Expected a valid bag after adding to it but got %s", err)
+			}
+
+			body, err := util.ReadKey(store, BAG_INFO_TXT)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, err = ParseBagInfo(body)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			org, _ := info.Get("Source-Organization")
+
+			if org != "example" {
+				t.Errorf("Expected Source-Organization to survive reopening but got '%s'", org)
+			}
+
+			oxum, _ := info.Get(INFO_PAYLOAD_OXUM)
+
+			if oxum != "31.4" {
+				t.Errorf("Expected a Payload-Oxum of 31.4 but got '%s'", oxum)
+			}
+
+			// a flipped byte
+
+			putKey(t, store, "data/123/123_abc_o.jpg", []byte("jpeG"))
+
+			err = Validate(ctx, store)
+
+			if err == nil || !strings.Contains(err.Error(), "data/123/123_abc_o.jpg checksum is") {
+				t.Errorf("Expected a checksum mismatch but got %v", err)
+			}
+
+			putKey(t, store, "data/123/123_abc_o.jpg", []byte("jpeg"))
+
+			// a file that isn't in the manifest
+
+			putKey(t, store, "data/789/789_ghi_o.jpg", []byte("jpeg"))
+
+			err = Validate(ctx, store)
+
+			if err == nil || !strings.Contains(err.Error(), "data/789/789_ghi_o.jpg is not listed") {
+				t.Errorf("Expected an unlisted file but got %v", err)
+			}
+
+			err = store.Delete("data/789/789_ghi_o.jpg")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = Validate(ctx, store)
+
+			if err != nil {
+				t.Errorf("Expected a valid bag once it was put back but got %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package bagit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// labels that are filled in by the bag itself
+
+const INFO_BAGGING_DATE string = "Bagging-Date"
+
+const INFO_PAYLOAD_OXUM string = "Payload-Oxum"
+
+const INFO_SOFTWARE_AGENT string = "Bag-Software-Agent"
+
+type BagInfoField struct {
+	Label string
+	Value string
+}
+
+// BagInfo is the contents of bag-info.txt. Order matters, and labels can be
+// repeated, so it isn't a map.
+
+type BagInfo []*BagInfoField
+
+func NewBagInfo() BagInfo {
+	return make([]*BagInfoField, 0)
+}
+
+// ParseBagInfo parses "Label: value" lines, including values that have been
+// wrapped on to indented lines.
+
+func ParseBagInfo(body []byte) (BagInfo, error) {
+
+	info := NewBagInfo()
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	lineno := 0
+
+	for scanner.Scan() {
+
+		line := strings.TrimRight(scanner.Text(), "\r")
+		lineno += 1
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+
+			if len(info) == 0 {
+				msg := fmt.Sprintf("Continuation without a label at line %d", lineno)
+				return nil, errors.New(msg)
+			}
+
+			last := info[len(info)-1]
+			last.Value = last.Value + " " + strings.TrimSpace(line)
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+
+		if len(parts) != 2 {
+			msg := fmt.Sprintf("Invalid bag-info line %d '%s'", lineno, line)
+			return nil, errors.New(msg)
+		}
+
+		info.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+
+	err := scanner.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// ParseBagInfoField parses a single "Label: value" string, for example
+// from the command line.
+
+func ParseBagInfoField(str string) (*BagInfoField, error) {
+
+	parts := strings.SplitN(str, ":", 2)
+
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		msg := fmt.Sprintf("Invalid bag-info field '%s', expected 'Label: value'", str)
+		return nil, errors.New(msg)
+	}
+
+	f := BagInfoField{
+		Label: strings.TrimSpace(parts[0]),
+		Value: strings.TrimSpace(parts[1]),
+	}
+
+	return &f, nil
+}
+
+func (info *BagInfo) Add(label string, value string) {
+
+	f := BagInfoField{
+		Label: label,
+		Value: value,
+	}
+
+	*info = append(*info, &f)
+}
+
+// Set replaces every existing value for label with value.
+
+func (info *BagInfo) Set(label string, value string) {
+
+	fields := NewBagInfo()
+	set := false
+
+	for _, f := range *info {
+
+		if !strings.EqualFold(f.Label, label) {
+			fields = append(fields, f)
+			continue
+		}
+
+		if !set {
+			fields = append(fields, &BagInfoField{Label: label, Value: value})
+			set = true
+		}
+	}
+
+	if !set {
+		fields.Add(label, value)
+	}
+
+	*info = fields
+}
+
+func (info BagInfo) Get(label string) (string, bool) {
+
+	for _, f := range info {
+
+		if strings.EqualFold(f.Label, label) {
+			return f.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// Merge replaces any labels in info that are also in other with the values
+// from other, keeping repeated labels repeated.
+
+func (info *BagInfo) Merge(other BagInfo) {
+
+	fields := NewBagInfo()
+
+	for _, f := range *info {
+
+		_, replaced := other.Get(f.Label)
+
+		if !replaced {
+			fields = append(fields, f)
+		}
+	}
+
+	for _, f := range other {
+		fields.Add(f.Label, f.Value)
+	}
+
+	*info = fields
+}
+
+func (info BagInfo) Bytes() []byte {
+
+	var buf bytes.Buffer
+
+	for _, f := range info {
+
+		// values can't span lines without being wrapped, which we don't
+		// bother with
+
+		value := strings.Join(strings.Fields(f.Value), " ")
+		buf.WriteString(fmt.Sprintf("%s: %s\n", f.Label, value))
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,113 @@
+package bagit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+)
+
+// the algorithm bags are written with; the others are only for validating
+// bags from somewhere else
+
+const ALGORITHM_SHA256 string = "sha256"
+
+var algorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// ManifestEntry is one line of a manifest. Path is relative to the root of
+// the bag, so payload files start with "data/".
+
+type ManifestEntry struct {
+	Checksum string
+	Path     string
+}
+
+func ParseManifest(body []byte) ([]*ManifestEntry, error) {
+
+	entries := make([]*ManifestEntry, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	lineno := 0
+
+	for scanner.Scan() {
+
+		line := strings.TrimRight(scanner.Text(), "\r")
+		lineno += 1
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		// paths can contain spaces but checksums can't
+
+		idx := strings.IndexAny(line, " \t")
+
+		if idx == -1 {
+			msg := fmt.Sprintf("Invalid manifest line %d '%s'", lineno, line)
+			return nil, errors.New(msg)
+		}
+
+		e := ManifestEntry{
+			Checksum: strings.ToLower(line[:idx]),
+			Path:     decodePath(strings.TrimLeft(line[idx:], " \t")),
+		}
+
+		entries = append(entries, &e)
+	}
+
+	err := scanner.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// manifestBytes writes checksums, keyed by path, as a manifest sorted by
+// path.
+
+func manifestBytes(checksums map[string]string) []byte {
+
+	paths := make([]string, 0)
+
+	for p := range checksums {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+
+	for _, p := range paths {
+		buf.WriteString(fmt.Sprintf("%s  %s\n", checksums[p], encodePath(p)))
+	}
+
+	return buf.Bytes()
+}
+
+// the only characters that need escaping in manifest paths (RFC 8493 2.1.3)
+
+var path_encoder = strings.NewReplacer("%", "%25", "\n", "%0A", "\r", "%0D")
+
+var path_decoder = strings.NewReplacer("%25", "%", "%0A", "\n", "%0a", "\n", "%0D", "\r", "%0d", "\r")
+
+func encodePath(p string) string {
+	return path_encoder.Replace(p)
+}
+
+func decodePath(p string) string {
+	return path_decoder.Replace(p)
+}
@@ -0,0 +1,60 @@
+package bagit
+
+import (
+	"context"
+	"github.com/aaronland/go-flickr-archive/util"
+	"github.com/aaronland/go-storage"
+	"sort"
+)
+
+// Package copies everything in source, apart from bookkeeping files, in to
+// the payload of bag. It doesn't close bag.
+
+func Package(ctx context.Context, source storage.Store, bag Bag) error {
+
+	keys := make([]string, 0)
+
+	cb := func(p string, args ...interface{}) error {
+
+		key := util.StoreKey(source, p)
+
+		if !isBookkeeping(key) {
+			keys = append(keys, key)
+		}
+
+		return nil
+	}
+
+	err := source.Walk(cb)
+
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// pass
+		}
+
+		fh, err := source.Get(key)
+
+		if err != nil {
+			return err
+		}
+
+		err = bag.Put(key, fh)
+		fh.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,301 @@
+package bagit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-flickr-archive/util"
+	"github.com/aaronland/go-storage"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var re_manifest = regexp.MustCompile(`^manifest-(\w+)\.txt$`)
+
+var re_tagmanifest = regexp.MustCompile(`^tagmanifest-(\w+)\.txt$`)
+
+// Validate checks that the bag in store is complete and that every checksum
+// in every manifest (and tag manifest) matches, which is what RFC 8493 calls
+// valid. It returns all the problems it finds, joined, rather than stopping
+// at the first one.
+
+func Validate(ctx context.Context, store storage.Store) error {
+
+	problems := make([]error, 0)
+
+	problem := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		problems = append(problems, errors.New(msg))
+	}
+
+	body, err := util.ReadKey(store, BAGIT_TXT)
+
+	if err != nil {
+		msg := fmt.Sprintf("Not a bag, unable to read %s: %s", BAGIT_TXT, err)
+		return errors.New(msg)
+	}
+
+	declaration, err := ParseBagInfo(body)
+
+	if err != nil {
+		return err
+	}
+
+	_, ok := declaration.Get("BagIt-Version")
+
+	if !ok {
+		problem("%s is missing BagIt-Version", BAGIT_TXT)
+	}
+
+	payload := make(map[string]bool)
+	manifests := make(map[string]string)
+	tagmanifests := make(map[string]string)
+
+	cb := func(p string, args ...interface{}) error {
+
+		key := util.StoreKey(store, p)
+
+		if isPayloadPath(key) {
+			payload[key] = true
+			return nil
+		}
+
+		m := re_manifest.FindStringSubmatch(key)
+
+		if m != nil {
+			manifests[key] = m[1]
+			return nil
+		}
+
+		m = re_tagmanifest.FindStringSubmatch(key)
+
+		if m != nil {
+			tagmanifests[key] = m[1]
+		}
+
+		return nil
+	}
+
+	err = store.Walk(cb)
+
+	if err != nil {
+		return err
+	}
+
+	if len(manifests) == 0 {
+		problem("Bag has no payload manifest")
+	}
+
+	// the size of every file that has been read, for the Payload-Oxum
+
+	sizes := make(map[string]int64)
+
+	for _, name := range sortedKeys(manifests) {
+
+		listed, err := validateManifest(ctx, store, name, manifests[name], sizes, problem)
+
+		if err != nil {
+			return err
+		}
+
+		for p := range payload {
+
+			if !listed[p] {
+				problem("%s is not listed in %s", p, name)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(tagmanifests) {
+
+		_, err := validateManifest(ctx, store, name, tagmanifests[name], sizes, problem)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	info_body, err := util.ReadKey(store, BAG_INFO_TXT)
+
+	if err == nil {
+
+		info, err := ParseBagInfo(info_body)
+
+		if err != nil {
+			problem("Unable to parse %s: %s", BAG_INFO_TXT, err)
+		} else {
+
+			oxum, ok := info.Get(INFO_PAYLOAD_OXUM)
+
+			if ok {
+
+				err := validateOxum(store, oxum, payload, sizes)
+
+				if err != nil {
+					problems = append(problems, err)
+				}
+			}
+		}
+	}
+
+	return errors.Join(problems...)
+}
+
+// validateManifest checks every file listed in the manifest called name and
+// returns the paths it lists, recording the size of each one in sizes.
+// Problems with the bag are passed to problem; errors are only returned for
+// things like being cancelled.
+
+func validateManifest(ctx context.Context, store storage.Store, name string, alg string, sizes map[string]int64, problem func(string, ...interface{})) (map[string]bool, error) {
+
+	listed := make(map[string]bool)
+
+	new_hash, ok := algorithms[alg]
+
+	if !ok {
+		problem("%s uses an unsupported algorithm (%s)", name, alg)
+		return listed, nil
+	}
+
+	body, err := util.ReadKey(store, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ParseManifest(body)
+
+	if err != nil {
+		problem("Unable to parse %s: %s", name, err)
+		return listed, nil
+	}
+
+	for _, e := range entries {
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+			// pass
+		}
+
+		listed[e.Path] = true
+
+		// don't go looking outside the bag
+
+		if path.IsAbs(e.Path) || path.Clean(e.Path) != e.Path || strings.HasPrefix(e.Path, "../") {
+			problem("%s lists an invalid path '%s'", name, e.Path)
+			continue
+		}
+
+		exists, err := store.Exists(e.Path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			problem("%s is listed in %s but missing", e.Path, name)
+			continue
+		}
+
+		fh, err := store.Get(e.Path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		hr := util.NewHashingReader(fh, new_hash())
+		_, err = io.Copy(ioutil.Discard, hr)
+
+		hr.Close()
+
+		if err != nil {
+			problem("Unable to read %s: %s", e.Path, err)
+			continue
+		}
+
+		sizes[e.Path] = hr.Size()
+
+		if hr.Checksum() != e.Checksum {
+			problem("%s checksum is %s but %s says %s", e.Path, hr.Checksum(), name, e.Checksum)
+		}
+	}
+
+	return listed, nil
+}
+
+func validateOxum(store storage.Store, oxum string, payload map[string]bool, sizes map[string]int64) error {
+
+	parts := strings.Split(oxum, ".")
+
+	if len(parts) != 2 {
+		msg := fmt.Sprintf("Invalid %s '%s'", INFO_PAYLOAD_OXUM, oxum)
+		return errors.New(msg)
+	}
+
+	octets, err := strconv.ParseInt(parts[0], 10, 64)
+
+	if err != nil {
+		msg := fmt.Sprintf("Invalid %s '%s'", INFO_PAYLOAD_OXUM, oxum)
+		return errors.New(msg)
+	}
+
+	count, err := strconv.Atoi(parts[1])
+
+	if err != nil {
+		msg := fmt.Sprintf("Invalid %s '%s'", INFO_PAYLOAD_OXUM, oxum)
+		return errors.New(msg)
+	}
+
+	if count != len(payload) {
+		msg := fmt.Sprintf("%s says there are %d payload files but there are %d", INFO_PAYLOAD_OXUM, count, len(payload))
+		return errors.New(msg)
+	}
+
+	total := int64(0)
+
+	for p := range payload {
+
+		size, ok := sizes[p]
+
+		// files that aren't in any manifest haven't been read yet
+
+		if !ok {
+
+			f, err := hashKey(store, p)
+
+			if err != nil {
+				return err
+			}
+
+			size = f.size
+		}
+
+		total += size
+	}
+
+	if total != octets {
+		msg := fmt.Sprintf("%s says there are %d payload bytes but there are %d", INFO_PAYLOAD_OXUM, octets, total)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+
+	keys := make([]string, 0)
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,212 @@
+package checkpoint
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-flickr-archive/util"
+	"github.com/aaronland/go-storage"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const CHECKPOINT_PREFIX string = "_checkpoints"
+
+// Checkpoint records how far a paginated method (or a sliding date window
+// search, in which case Method is the name of the search) has got. Page is
+// the last page that was completely archived. Start and End are the Unix
+// timestamps of the next date window to search, if there is one, or of the
+// whole of an adaptive search so that it can be resumed with the same dates.
+
+type Checkpoint struct {
+	Method    string     `json:"method"`
+	Query     url.Values `json:"query"`
+	Page      int        `json:"page,omitempty"`
+	Pages     int        `json:"pages,omitempty"`
+	Start     int64      `json:"start,omitempty"`
+	End       int64      `json:"end,omitempty"`
+	Timestamp int64      `json:"timestamp"`
+}
+
+type Checkpoints interface {
+	Get(string, url.Values) (*Checkpoint, error)
+	Record(*Checkpoint) error
+	Clear() error
+}
+
+func NewCheckpoint(method string, query url.Values) *Checkpoint {
+
+	cp := Checkpoint{
+		Method:    method,
+		Query:     query,
+		Timestamp: time.Now().Unix(),
+	}
+
+	return &cp
+}
+
+// Complete reports whether every page has been archived.
+
+func (cp *Checkpoint) Complete() bool {
+	return cp.Pages > 0 && cp.Page >= cp.Pages
+}
+
+// Key returns the identifier for method and query, ignoring the page
+// parameter which is the thing being checkpointed.
+
+func Key(method string, query url.Values) string {
+
+	q := url.Values{}
+
+	for k, v := range query {
+
+		if k == "page" {
+			continue
+		}
+
+		q[k] = v
+	}
+
+	// url.Values.Encode sorts by key so this is stable
+
+	h := sha1.Sum([]byte(method + "?" + q.Encode()))
+	return hex.EncodeToString(h[:])
+}
+
+// StoreCheckpoints writes one file per checkpoint, like journal.StoreJournal.
+
+type StoreCheckpoints struct {
+	Checkpoints
+	store  storage.Store
+	prefix string
+}
+
+func NewStoreCheckpoints(store storage.Store) (Checkpoints, error) {
+
+	c := StoreCheckpoints{
+		store:  store,
+		prefix: CHECKPOINT_PREFIX,
+	}
+
+	return &c, nil
+}
+
+// NewStoreCheckpointsForQuery returns checkpoints that are kept apart from
+// those of any other search (method and query) written to store, so that
+// clearing them leaves the checkpoints for other searches alone.
+
+func NewStoreCheckpointsForQuery(store storage.Store, method string, query url.Values) (Checkpoints, error) {
+
+	c := StoreCheckpoints{
+		store:  store,
+		prefix: path.Join(CHECKPOINT_PREFIX, Key(method, query)),
+	}
+
+	return &c, nil
+}
+
+// Get returns the checkpoint for method and query or nil if there isn't one.
+
+func (c *StoreCheckpoints) Get(method string, query url.Values) (*Checkpoint, error) {
+
+	key := c.key(method, query)
+
+	exists, err := c.store.Exists(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	fh, err := c.store.Get(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	body, err := ioutil.ReadAll(fh)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var cp Checkpoint
+
+	err = json.Unmarshal(body, &cp)
+
+	if err != nil {
+		msg := fmt.Sprintf("Failed to parse checkpoint %s: %s", key, err)
+		return nil, errors.New(msg)
+	}
+
+	return &cp, nil
+}
+
+func (c *StoreCheckpoints) Record(cp *Checkpoint) error {
+
+	cp.Timestamp = time.Now().Unix()
+
+	enc, err := json.Marshal(cp)
+
+	if err != nil {
+		return err
+	}
+
+	key := c.key(cp.Method, cp.Query)
+
+	fh := ioutil.NopCloser(bytes.NewReader(enc))
+	return util.Replace(c.store, key, fh)
+}
+
+// Clear removes every checkpoint (or, if c was created with
+// NewStoreCheckpointsForQuery, every checkpoint for that search), which is
+// what you want to do once a search has finished (or when starting one over).
+
+func (c *StoreCheckpoints) Clear() error {
+
+	keys := make([]string, 0)
+
+	cb := func(path string, args ...interface{}) error {
+
+		key := util.StoreKey(c.store, path)
+
+		if strings.HasPrefix(key, c.prefix+"/") && filepath.Ext(key) == ".json" {
+			keys = append(keys, key)
+		}
+
+		return nil
+	}
+
+	err := c.store.Walk(cb)
+
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+
+		err := c.store.Delete(key)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *StoreCheckpoints) key(method string, query url.Values) string {
+	return fmt.Sprintf("%s/%s.json", c.prefix, Key(method, query))
+}
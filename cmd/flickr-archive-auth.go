@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/aaronland/go-flickr-archive/flickr"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+
+	default_tokens, err := flickr.DefaultTokenStorePath()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var key = flag.String("api-key", "", "A valid Flickr API key.")
+	var secret = flag.String("api-secret", "", "A valid Flickr API secret.")
+	var perms = flag.String("perms", "read", "The permissions to request. Valid options are: read, write, delete.")
+
+	var account = flag.String("account", "", "The name to store credentials under. Default is the Flickr username of the authorizing account.")
+	var tokens = flag.String("tokens", default_tokens, "The path to the file where OAuth credentials are stored.")
+
+	flag.Parse()
+
+	if *key == "" || *secret == "" {
+		log.Fatal("Missing -api-key or -api-secret")
+	}
+
+	ts, err := flickr.NewTokenStore(*tokens)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rt, err := flickr.GetOAuthRequestToken(*key, *secret, "oob")
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	auth_url := flickr.OAuthAuthorizationURL(rt, *perms)
+
+	fmt.Println("Visit the following URL in your web browser and authorize this application:")
+	fmt.Println("")
+	fmt.Println(auth_url)
+	fmt.Println("")
+	fmt.Print("Enter the verification code: ")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Scan()
+
+	err = scanner.Err()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	verifier := strings.TrimSpace(scanner.Text())
+
+	if verifier == "" {
+		log.Fatal("Missing verification code")
+	}
+
+	at, err := flickr.GetOAuthAccessToken(*key, *secret, rt, verifier)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	name := *account
+
+	if name == "" {
+		name = at.Username
+	}
+
+	creds := flickr.Credentials{
+		Key:         *key,
+		Secret:      *secret,
+		Token:       at.Token,
+		TokenSecret: at.Secret,
+		UserNSID:    at.UserNSID,
+		Username:    at.Username,
+		Perms:       *perms,
+		Created:     time.Now().Unix(),
+	}
+
+	err = ts.Set(name, &creds)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Stored credentials for %s (%s) as '%s' in %s\n", at.Username, at.UserNSID, name, ts.Path())
+}
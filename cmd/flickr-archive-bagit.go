@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/aaronland/go-flickr-archive/bagit"
+	"github.com/aaronland/go-flickr-archive/stores"
+	"github.com/whosonfirst/go-whosonfirst-cli/flags"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+
+	var storage_uri = flag.String("storage", "", "A URI for the archive to package or, with -validate, the bag to validate. Valid schemes are: fs://, mem:// and s3://. Anything else is treated as a path on the local filesystem.")
+	var target_uri = flag.String("target", "", "A URI for where the bag should be written. Valid schemes are: fs://, mem://, s3://, tar:// and zip://. Anything else is treated as a path on the local filesystem.")
+
+	var info flags.MultiString
+	flag.Var(&info, "info", "A 'Label: value' field to add to bag-info.txt. May be passed multiple times.")
+
+	var validate = flag.Bool("validate", false, "Validate the bag in -storage rather than packaging anything.")
+
+	flag.Parse()
+
+	err := stores.RequireReadable(*storage_uri, "flickr-archive-bagit")
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := stores.NewStore(*storage_uri)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *validate {
+
+		err = bagit.Validate(ctx, store)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("%s is valid\n", store.URI(""))
+		return
+	}
+
+	bag_info := bagit.NewBagInfo()
+
+	for _, str_field := range info {
+
+		f, err := bagit.ParseBagInfoField(str_field)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		bag_info.Add(f.Label, f.Value)
+	}
+
+	target, err := stores.NewStore(*target_uri)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bag, err := bagit.NewBag(target, bag_info)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = bagit.Package(ctx, store, bag)
+
+	close_err := bag.Close()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if close_err != nil {
+		log.Fatal(close_err)
+	}
+}
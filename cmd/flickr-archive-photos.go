@@ -1,38 +1,100 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"github.com/aaronland/go-flickr-archive/archivist"
 	"github.com/aaronland/go-flickr-archive/flickr"
 	"github.com/aaronland/go-flickr-archive/photo"
-	"github.com/aaronland/go-storage"
+	"github.com/aaronland/go-flickr-archive/stores"
+	"github.com/aaronland/go-flickr-archive/throttle"
+	"github.com/whosonfirst/go-whosonfirst-cli/flags"
 	"log"
-	"path/filepath"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
 
-	var key = flag.String("api-key", "", "...")
-	var secret = flag.String("api-secret", "", "...")
+	default_tokens, err := flickr.DefaultTokenStorePath()
 
-	// please support other storage layers...
-	var root = flag.String("root", "", "...")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var account = flag.String("account", "", "The name of the account whose OAuth credentials should be used (see flickr-archive-auth).")
+	var tokens = flag.String("tokens", default_tokens, "The path to the file where OAuth credentials are stored.")
+
+	var storage_uri = flag.String("storage", "", "A URI for where photos should be archived. Valid schemes are: fs://, mem://, s3://, tar:// and zip://. Anything else is treated as a path on the local filesystem.")
+	var root = flag.String("root", "", "Deprecated: use -storage instead.")
+
+	var sizes flags.MultiString
+	flag.Var(&sizes, "size", "A Flickr size label (for example 'Original' or 'Large') to archive. May be passed multiple times, in order of preference. Default is the largest size available.")
+
+	var sizes_mode = flag.String("sizes-mode", archivist.SIZES_MODE_FIRST, "Whether to archive the 'first' of the -size labels that exists or 'all' of them.")
+
+	var archive_info = flag.Bool("archive-info", true, "Archive the response from flickr.photos.getInfo for each photo.")
+	var archive_sizes = flag.Bool("archive-sizes", false, "Archive the response from flickr.photos.getSizes for each photo.")
+	var archive_exif = flag.Bool("archive-exif", false, "Archive the response from flickr.photos.getExif for each photo.")
+	var archive_comments = flag.Bool("archive-comments", false, "Archive the response from flickr.photos.comments.getList for each photo.")
+
+	var continue_on_error = flag.Bool("continue-on-error", false, "Record photos that fail to be archived in a journal (see flickr-archive-retry) rather than stopping.")
+
+	var incremental = flag.Bool("incremental", false, "Only archive photos that are new or have changed since they were last archived. Not supported for tar:// and zip:// storage.")
+
+	var timeout = flag.Duration("timeout", 0, "The maximum amount of time to let the archiving process run. Default is no timeout.")
+
+	var layout_name = flag.String("layout", archivist.LAYOUT_ID, "How files are arranged in the archive. Valid options are: id, flat, sharded, date or a Go template (for example '{{.Owner}}/{{.Year}}/{{.ID}}'). See also: flickr-archive-relayout.")
+
+	var rate = flag.Float64("rate", 10, "The maximum number of requests per second, shared by Flickr API calls and photo downloads.")
 
 	flag.Parse()
 
-	abs_root, err := filepath.Abs(*root)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *timeout > 0 {
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	if *storage_uri == "" {
+		*storage_uri = *root
+	}
+
+	if *incremental {
+
+		err := stores.RequireReadable(*storage_uri, "-incremental")
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	api, err := flickr.NewFlickrAuthAPIForAccount(*tokens, *account)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	api, err := flickr.NewFlickrAuthAPI(*key, *secret)
+	// one throttle for both API calls and downloads
+
+	t, err := throttle.NewTokenBucket(*rate, 1)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	store, err := storage.NewFSStore(abs_root)
+	auth_api, ok := api.(*flickr.FlickrAuthAPI)
+
+	if ok {
+		auth_api.Throttle = t
+	}
+
+	store, err := stores.NewStore(*storage_uri)
 
 	if err != nil {
 		log.Fatal(err)
@@ -44,6 +106,37 @@ func main() {
 		log.Fatal(err)
 	}
 
+	labels := opts.Sizes.Labels
+
+	if len(sizes) > 0 {
+		labels = sizes
+	}
+
+	policy, err := archivist.NewSizePolicy(*sizes_mode, labels...)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.Sizes = policy
+
+	opts.ArchiveInfo = *archive_info
+	opts.ArchiveSizes = *archive_sizes
+	opts.ArchiveEXIF = *archive_exif
+	opts.ArchiveComments = *archive_comments
+	opts.ContinueOnError = *continue_on_error
+	opts.Incremental = *incremental
+
+	opts.Throttle = t
+
+	layout, err := archivist.NewLayout(*layout_name)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.Layout = layout
+
 	arch, err := archivist.NewStaticArchivist(store, opts)
 
 	if err != nil {
@@ -63,10 +156,16 @@ func main() {
 		photos = append(photos, ph)
 	}
 
-	err = arch.ArchivePhotos(api, photos...)
+	err = arch.ArchivePhotosWithContext(ctx, api, photos...)
+
+	close_err := stores.Close(store)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if close_err != nil {
+		log.Fatal(close_err)
+	}
+
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aaronland/go-flickr-archive/archivist"
+	"github.com/aaronland/go-flickr-archive/stores"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+
+	var storage_uri = flag.String("storage", "", "A URI for the archive to relayout. Valid schemes are: fs:// and s3://. Anything else is treated as a path on the local filesystem.")
+	var target_uri = flag.String("target", "", "An optional URI for where the relaid out archive should be written. Valid schemes are: fs://, s3://, tar:// and zip://. Default is to move files around in -storage.")
+
+	var layout_name = flag.String("layout", archivist.LAYOUT_SHARDED, "The layout to migrate to. Valid options are: id, flat, sharded, date or a Go template (for example '{{.Owner}}/{{.Year}}/{{.ID}}').")
+
+	flag.Parse()
+
+	if *target_uri == *storage_uri {
+		*target_uri = ""
+	}
+
+	// tar and zip archives can't be read back (and opening one replaces
+	// it) and a mem:// store starts out empty, so none of them have
+	// anything to relayout
+
+	switch stores.Scheme(*storage_uri) {
+	case "mem", "tar", "zip":
+		msg := fmt.Sprintf("Can not relayout %s:// storage", stores.Scheme(*storage_uri))
+		log.Fatal(errors.New(msg))
+	default:
+		// pass
+	}
+
+	if stores.Scheme(*target_uri) == "mem" {
+		log.Fatal("A mem:// target would be thrown away once the relayout is done")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	layout, err := archivist.NewLayout(*layout_name)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	source, err := stores.NewStore(*storage_uri)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	target := source
+
+	if *target_uri != "" {
+
+		target, err = stores.NewStore(*target_uri)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	err = archivist.Relayout(ctx, source, target, layout)
+
+	close_err := stores.Close(target)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if close_err != nil {
+		log.Fatal(close_err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/aaronland/go-flickr-archive/archivist"
+	"github.com/aaronland/go-flickr-archive/flickr"
+	"github.com/aaronland/go-flickr-archive/journal"
+	"github.com/aaronland/go-flickr-archive/photo"
+	"github.com/aaronland/go-flickr-archive/stores"
+	"github.com/aaronland/go-flickr-archive/throttle"
+	"github.com/whosonfirst/go-whosonfirst-cli/flags"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+
+	default_tokens, err := flickr.DefaultTokenStorePath()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var account = flag.String("account", "", "The name of the account whose OAuth credentials should be used (see flickr-archive-auth).")
+	var tokens = flag.String("tokens", default_tokens, "The path to the file where OAuth credentials are stored.")
+
+	var storage_uri = flag.String("storage", "", "A URI for the archive whose failed photos should be retried. Valid schemes are: fs://, mem:// and s3://. Anything else is treated as a path on the local filesystem. The -size, -sizes-mode, -archive-* and -layout flags should be the same as the ones the archive was made with.")
+
+	var sizes flags.MultiString
+	flag.Var(&sizes, "size", "A Flickr size label (for example 'Original' or 'Large') to archive. May be passed multiple times, in order of preference. Default is the largest size available.")
+
+	var sizes_mode = flag.String("sizes-mode", archivist.SIZES_MODE_FIRST, "Whether to archive the 'first' of the -size labels that exists or 'all' of them.")
+
+	var archive_sizes = flag.Bool("archive-sizes", false, "Archive the response from flickr.photos.getSizes for each photo.")
+	var archive_exif = flag.Bool("archive-exif", false, "Archive the response from flickr.photos.getExif for each photo.")
+	var archive_comments = flag.Bool("archive-comments", false, "Archive the response from flickr.photos.comments.getList for each photo.")
+
+	var layout_name = flag.String("layout", archivist.LAYOUT_ID, "How files are arranged in the archive. Valid options are: id, flat, sharded, date or a Go template (for example '{{.Owner}}/{{.Year}}/{{.ID}}'). See also: flickr-archive-relayout.")
+
+	var rate = flag.Float64("rate", 10, "The maximum number of requests per second, shared by Flickr API calls and photo downloads.")
+
+	flag.Parse()
+
+	err = stores.RequireReadable(*storage_uri, "flickr-archive-retry")
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	api, err := flickr.NewFlickrAuthAPIForAccount(*tokens, *account)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// one throttle for both API calls and downloads
+
+	t, err := throttle.NewTokenBucket(*rate, 1)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	auth_api, ok := api.(*flickr.FlickrAuthAPI)
+
+	if ok {
+		auth_api.Throttle = t
+	}
+
+	store, err := stores.NewStore(*storage_uri)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	j, err := journal.NewStoreJournal(store)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts, err := archivist.DefaultStaticArchivistOptions()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	labels := opts.Sizes.Labels
+
+	if len(sizes) > 0 {
+		labels = sizes
+	}
+
+	policy, err := archivist.NewSizePolicy(*sizes_mode, labels...)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.Sizes = policy
+
+	opts.ArchiveSizes = *archive_sizes
+	opts.ArchiveEXIF = *archive_exif
+	opts.ArchiveComments = *archive_comments
+
+	opts.Throttle = t
+
+	layout, err := archivist.NewLayout(*layout_name)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.Layout = layout
+
+	arch, err := archivist.NewStaticArchivist(store, opts)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	entries, err := j.Entries()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	remaining := 0
+
+	for _, e := range entries {
+
+		ph, err := photo.NewFlickrPhoto(e.PhotoID)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = arch.ArchivePhoto(ctx, api, ph)
+
+		if ctx.Err() != nil {
+			log.Fatal(ctx.Err())
+		}
+
+		if err != nil {
+
+			log.Printf("Failed to archive photo %d (again): %s\n", e.PhotoID, err)
+
+			err = j.Record(journal.NewEntry(e.PhotoID, err))
+
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			remaining += 1
+			continue
+		}
+
+		err = j.Remove(e.PhotoID)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	err = stores.Close(store)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Retried %d photos, %d still failing\n", len(entries), remaining)
+}
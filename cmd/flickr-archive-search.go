@@ -1,47 +1,172 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"github.com/aaronland/go-flickr-archive/archivist"
+	"github.com/aaronland/go-flickr-archive/bagit"
+	"github.com/aaronland/go-flickr-archive/checkpoint"
 	"github.com/aaronland/go-flickr-archive/common"
 	"github.com/aaronland/go-flickr-archive/flickr"
+	"github.com/aaronland/go-flickr-archive/stores"
+	"github.com/aaronland/go-flickr-archive/throttle"
 	"github.com/aaronland/go-storage"
 	"github.com/whosonfirst/go-whosonfirst-cli/flags"
 	"log"
 	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+const BAGIT_FINAL string = "final"
+
+const BAGIT_CONTINUOUS string = "continuous"
+
 func main() {
 
-	var key = flag.String("api-key", "", "...")
-	var secret = flag.String("api-secret", "", "...")
+	default_tokens, err := flickr.DefaultTokenStorePath()
+
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	var storage_dsn = flag.String("storage", "", "...")
+	var account = flag.String("account", "", "The name of the account whose OAuth credentials should be used (see flickr-archive-auth).")
+	var tokens = flag.String("tokens", default_tokens, "The path to the file where OAuth credentials are stored.")
+
+	var storage_uri = flag.String("storage", "", "A URI for where photos should be archived. Valid schemes are: fs://, mem://, s3://, tar:// and zip://. Anything else is treated as a path on the local filesystem.")
 
 	var params flags.KeyValueArgs
 	flag.Var(&params, "param", "...")
 
+	var sizes flags.MultiString
+	flag.Var(&sizes, "size", "A Flickr size label (for example 'Original' or 'Large') to archive. May be passed multiple times, in order of preference. Default is the largest size available.")
+
+	var sizes_mode = flag.String("sizes-mode", archivist.SIZES_MODE_FIRST, "Whether to archive the 'first' of the -size labels that exists or 'all' of them.")
+
+	var archive_info = flag.Bool("archive-info", true, "Archive the response from flickr.photos.getInfo for each photo. This means calling flickr.photos.getInfo for every photo, even when -extras are requested, so use -archive-info=false to archive photos without it (although flickr-archive-relayout can only move photos that have one in to the date layout).")
+	var archive_sizes = flag.Bool("archive-sizes", false, "Archive the response from flickr.photos.getSizes for each photo.")
+	var archive_exif = flag.Bool("archive-exif", false, "Archive the response from flickr.photos.getExif for each photo.")
+	var archive_comments = flag.Bool("archive-comments", false, "Archive the response from flickr.photos.comments.getList for each photo.")
+
+	var continue_on_error = flag.Bool("continue-on-error", false, "Record photos that fail to be archived in a journal (see flickr-archive-retry) rather than stopping.")
+
+	var incremental = flag.Bool("incremental", false, "Only archive photos that are new or have changed since they were last archived. Not supported for tar:// and zip:// storage.")
+
+	var extras = flag.Bool("extras", true, "Request the search \"extras\" needed to archive photos without calling flickr.photos.getInfo and flickr.photos.getSizes for each one, where possible. See also: -archive-info.")
+
+	var adaptive = flag.Bool("adaptive", false, "Split the search in to date windows small enough that Flickr will return every result for each one, rather than only the first 4,000.")
+	var axis = flag.String("axis", common.AXIS_UPLOAD_DATE, "The date to split adaptive searches on. Valid options are: upload, taken.")
+	var min_date = flag.String("min-date", "", "The (YYYY-MM-DD) start date for adaptive searches. Default is 2004-01-01 for upload dates and 1900-01-01 for dates taken.")
+	var max_date = flag.String("max-date", "", "The (YYYY-MM-DD) end date, inclusive, for adaptive searches. Default is today.")
+	var timezone = flag.String("timezone", "UTC", "The (IANA) timezone that -min-date, -max-date and today are read in. Dates taken are wall-clock times so -min-date and -max-date are taken as they are for -axis taken.")
+
+	var resume = flag.Bool("resume", false, "Continue from where a previous run with the same search parameters stopped, rather than starting over. Adaptive searches keep the start and end dates of the run being resumed. Not supported for tar:// and zip:// storage.")
+
+	var timeout = flag.Duration("timeout", 0, "The maximum amount of time to let the archiving process run. Default is no timeout.")
+
+	var layout_name = flag.String("layout", archivist.LAYOUT_ID, "How files are arranged in the archive. Valid options are: id, flat, sharded, date or a Go template (for example '{{.Owner}}/{{.Year}}/{{.ID}}'). See also: flickr-archive-relayout.")
+
+	var rate = flag.Float64("rate", 10, "The maximum number of requests per second, shared by Flickr API calls and photo downloads.")
+
+	var bagit_mode = flag.String("bagit", "", "Package the archive as a BagIt bag. Valid options are: final (copy the archive in to a bag at -bagit-target once the search is done, not supported for tar:// and zip:// storage) or continuous (write the archive as a bag, in -storage, as it goes).")
+	var bagit_target = flag.String("bagit-target", "", "A URI for where the bag should be written in -bagit final mode.")
+	var bagit_flush = flag.Duration("bagit-flush", time.Minute, "How often to update the bag's manifests in -bagit continuous mode, so that it is valid even if the search is interrupted. 0 means only when the search is done. Ignored for tar:// and zip:// storage, where the bag is only finished once the search is done.")
+
+	var bagit_info flags.MultiString
+	flag.Var(&bagit_info, "bagit-info", "A 'Label: value' field to add to bag-info.txt. May be passed multiple times.")
+
 	flag.Parse()
 
-	api, err := flickr.NewFlickrAuthAPI(*key, *secret)
+	switch *bagit_mode {
+	case "", BAGIT_FINAL, BAGIT_CONTINUOUS:
+		// pass
+	default:
+		msg := fmt.Sprintf("Invalid -bagit mode '%s'", *bagit_mode)
+		log.Fatal(errors.New(msg))
+	}
+
+	if *bagit_mode == BAGIT_FINAL && *bagit_target == "" {
+		log.Fatal("-bagit final requires -bagit-target")
+	}
+
+	// the archive is copied in to the bag by reading it back, which tar
+	// and zip archives can't do
+
+	if *bagit_mode == BAGIT_FINAL {
+
+		err := stores.RequireReadable(*storage_uri, "-bagit final")
+
+		if err != nil {
+			msg := fmt.Sprintf("%s; use -bagit continuous instead", err)
+			log.Fatal(errors.New(msg))
+		}
+	}
+
+	if *incremental {
+
+		err := stores.RequireReadable(*storage_uri, "-incremental")
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *resume {
+
+		err := stores.RequireReadable(*storage_uri, "-resume")
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *timeout > 0 {
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	ts, err := flickr.NewTokenStore(*tokens)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	store, err := storage.NewFSStore(*storage_dsn)
+	creds, err := ts.Get(*account)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	opts, err := archivist.DefaultStaticArchivistOptions()
+	api, err := flickr.NewFlickrAuthAPIWithCredentials(creds)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	arch, err := archivist.NewStaticArchivist(store, opts)
+	// one throttle for both API calls and downloads
+
+	t, err := throttle.NewTokenBucket(*rate, 1)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	auth_api, ok := api.(*flickr.FlickrAuthAPI)
+
+	if ok {
+		auth_api.Throttle = t
+	}
+
+	store, err := stores.NewStore(*storage_uri)
 
 	if err != nil {
 		log.Fatal(err)
@@ -53,9 +178,277 @@ func main() {
 		query.Set(p.Key, p.Value)
 	}
 
-	err = common.ArchivePhotosWithSearch(arch, api, query)
+	// before any extras get added
+
+	bag_info := bagit.NewBagInfo()
+
+	if *bagit_mode != "" {
+
+		bag_info.Add("Flickr-Account-NSID", creds.UserNSID)
+		bag_info.Add("Flickr-Account-Username", creds.Username)
+		bag_info.Add("Flickr-Search-Query", query.Encode())
+
+		if *min_date != "" {
+			bag_info.Add("Flickr-Search-Min-Date", *min_date)
+		}
+
+		if *max_date != "" {
+			bag_info.Add("Flickr-Search-Max-Date", *max_date)
+		}
+
+		for _, str_field := range bagit_info {
+
+			f, err := bagit.ParseBagInfoField(str_field)
+
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			bag_info.Add(f.Label, f.Value)
+		}
+	}
+
+	if *bagit_mode == BAGIT_CONTINUOUS {
+
+		bag, err := bagit.NewBag(store, bag_info)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		store = bag
+
+		if *bagit_flush > 0 {
+
+			go func() {
+
+				ticker := time.NewTicker(*bagit_flush)
+				defer ticker.Stop()
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+
+						err := bag.Flush()
+
+						if err != nil {
+							log.Printf("Failed to flush bag: %s\n", err)
+						}
+					}
+				}
+			}()
+		}
+	}
+
+	opts, err := archivist.DefaultStaticArchivistOptions()
 
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	labels := opts.Sizes.Labels
+
+	if len(sizes) > 0 {
+		labels = sizes
+	}
+
+	policy, err := archivist.NewSizePolicy(*sizes_mode, labels...)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.Sizes = policy
+
+	opts.ArchiveInfo = *archive_info
+	opts.ArchiveSizes = *archive_sizes
+	opts.ArchiveEXIF = *archive_exif
+	opts.ArchiveComments = *archive_comments
+	opts.ContinueOnError = *continue_on_error
+	opts.Incremental = *incremental
+
+	opts.Throttle = t
+
+	layout, err := archivist.NewLayout(*layout_name)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.Layout = layout
+
+	arch, err := archivist.NewStaticArchivist(store, opts)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *extras {
+		flickr.AppendExtras(query, flickr.ARCHIVE_EXTRAS...)
+		flickr.AppendExtras(query, flickr.URLExtrasForSizes(opts.Sizes.Labels...)...)
+	}
+
+	// only this search's checkpoints, so that starting it over doesn't
+	// throw away the progress of other searches in the same archive
+
+	cp, err := checkpoint.NewStoreCheckpointsForQuery(store, "flickr.photos.search", query)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !*resume {
+
+		err = cp.Clear()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *adaptive {
+
+		loc, loc_err := time.LoadLocation(*timezone)
+
+		if loc_err != nil {
+			log.Fatal(loc_err)
+		}
+
+		// dates taken are wall-clock times so the dates for them are read
+		// as such, see common.WallClock
+
+		dates_loc := loc
+		start := common.FLICKR_EPOCH
+
+		if *axis == common.AXIS_TAKEN_DATE {
+			dates_loc = time.UTC
+			start = time.Date(1900, 1, 1, 0, 0, 0, 0, dates_loc)
+		}
+
+		y, m, d := time.Now().In(loc).Date()
+		end := time.Date(y, m, d+1, 0, 0, 0, 0, dates_loc)
+
+		if *min_date != "" {
+
+			start, err = time.ParseInLocation("2006-01-02", *min_date, dates_loc)
+
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if *max_date != "" {
+
+			dt, err := time.ParseInLocation("2006-01-02", *max_date, dates_loc)
+
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			end = dt.AddDate(0, 0, 1)
+		}
+
+		// the windows that get searched depend on start and end, and the
+		// default end moves at midnight, so a resumed search uses the ones
+		// that the search it's resuming was started with
+
+		window_method := fmt.Sprintf("adaptive:%s", *axis)
+
+		if *resume {
+
+			c, c_err := cp.Get(window_method, query)
+
+			if c_err != nil {
+				log.Fatal(c_err)
+			}
+
+			if c != nil {
+
+				resumed_start := time.Unix(c.Start, 0).In(dates_loc)
+				resumed_end := time.Unix(c.End, 0).In(dates_loc)
+
+				if (*min_date != "" && !start.Equal(resumed_start)) || (*max_date != "" && !end.Equal(resumed_end)) {
+					msg := fmt.Sprintf("The search being resumed runs from %s to %s, -min-date and -max-date must match or be left out", resumed_start.Format("2006-01-02"), resumed_end.AddDate(0, 0, -1).Format("2006-01-02"))
+					log.Fatal(errors.New(msg))
+				}
+
+				start = resumed_start
+				end = resumed_end
+			}
+		}
+
+		window_cp := checkpoint.NewCheckpoint(window_method, query)
+		window_cp.Start = start.Unix()
+		window_cp.End = end.Unix()
+
+		err = cp.Record(window_cp)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w, w_err := common.NewWindow(start, end)
+
+		if w_err != nil {
+			log.Fatal(w_err)
+		}
+
+		search_opts := common.DefaultAdaptiveSearchOptions()
+		search_opts.Axis = *axis
+		search_opts.Location = loc
+		search_opts.Checkpoints = cp
+
+		err = common.ArchivePhotosWithAdaptiveSearch(ctx, arch, api, query, w, search_opts)
+
+	} else {
+
+		err = common.ArchivePhotosWithSearchWithCheckpoints(ctx, arch, api, query, cp)
+	}
+
+	if err == nil {
+		err = cp.Clear()
+	}
+
+	if err == nil && *bagit_mode == BAGIT_FINAL {
+		err = packageBag(ctx, store, *bagit_target, bag_info)
+	}
+
+	close_err := stores.Close(store)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if close_err != nil {
+		log.Fatal(close_err)
+	}
+}
+
+// packageBag copies everything in store in to a new bag at target_uri.
+
+func packageBag(ctx context.Context, store storage.Store, target_uri string, info bagit.BagInfo) error {
+
+	target, err := stores.NewStore(target_uri)
+
+	if err != nil {
+		return err
+	}
+
+	bag, err := bagit.NewBag(target, info)
+
+	if err != nil {
+		return err
+	}
+
+	err = bagit.Package(ctx, store, bag)
+
+	close_err := bag.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return close_err
 }
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/aaronland/go-flickr-archive/archivist"
+	"github.com/aaronland/go-flickr-archive/checkpoint"
+	"github.com/aaronland/go-flickr-archive/common"
+	"github.com/aaronland/go-flickr-archive/flickr"
+	"github.com/aaronland/go-flickr-archive/stores"
+	"github.com/aaronland/go-flickr-archive/throttle"
+	"github.com/aaronland/go-flickr-archive/user"
+	"github.com/whosonfirst/go-whosonfirst-cli/flags"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+
+	default_tokens, err := flickr.DefaultTokenStorePath()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var account = flag.String("account", "", "The name of the account whose OAuth credentials should be used (see flickr-archive-auth).")
+	var tokens = flag.String("tokens", default_tokens, "The path to the file where OAuth credentials are stored.")
+
+	var username = flag.String("username", "", "The Flickr username whose photos should be archived. Default is the username associated with -account.")
+
+	var storage_uri = flag.String("storage", "", "A URI for where photos should be archived. Valid schemes are: fs://, mem://, s3://, tar:// and zip://. Anything else is treated as a path on the local filesystem.")
+
+	var sizes flags.MultiString
+	flag.Var(&sizes, "size", "A Flickr size label (for example 'Original' or 'Large') to archive. May be passed multiple times, in order of preference. Default is the largest size available.")
+
+	var sizes_mode = flag.String("sizes-mode", archivist.SIZES_MODE_FIRST, "Whether to archive the 'first' of the -size labels that exists or 'all' of them.")
+
+	var archive_info = flag.Bool("archive-info", true, "Archive the response from flickr.photos.getInfo for each photo. This means calling flickr.photos.getInfo for every photo, even when -extras are requested, so use -archive-info=false to archive photos without it (although flickr-archive-relayout can only move photos that have one in to the date layout).")
+	var archive_sizes = flag.Bool("archive-sizes", false, "Archive the response from flickr.photos.getSizes for each photo.")
+	var archive_exif = flag.Bool("archive-exif", false, "Archive the response from flickr.photos.getExif for each photo.")
+	var archive_comments = flag.Bool("archive-comments", false, "Archive the response from flickr.photos.comments.getList for each photo.")
+
+	var continue_on_error = flag.Bool("continue-on-error", false, "Record photos that fail to be archived in a journal (see flickr-archive-retry) rather than stopping.")
+
+	var incremental = flag.Bool("incremental", false, "Only archive photos that are new or have changed since they were last archived. Not supported for tar:// and zip:// storage.")
+
+	var extras = flag.Bool("extras", true, "Request the search \"extras\" needed to archive photos without calling flickr.photos.getInfo and flickr.photos.getSizes for each one, where possible. See also: -archive-info.")
+
+	var axis = flag.String("axis", common.AXIS_UPLOAD_DATE, "The date to walk through a user's photos by. Valid options are: upload, taken.")
+	var timezone = flag.String("timezone", "UTC", "The (IANA) timezone that days are worked out in. Dates taken are wall-clock times and are searched as they are.")
+
+	var resume = flag.Bool("resume", false, "Continue from where a previous run with the same search parameters stopped, rather than starting over. Not supported for tar:// and zip:// storage.")
+
+	var timeout = flag.Duration("timeout", 0, "The maximum amount of time to let the archiving process run. Default is no timeout.")
+
+	var layout_name = flag.String("layout", archivist.LAYOUT_ID, "How files are arranged in the archive. Valid options are: id, flat, sharded, date or a Go template (for example '{{.Owner}}/{{.Year}}/{{.ID}}'). See also: flickr-archive-relayout.")
+
+	var rate = flag.Float64("rate", 10, "The maximum number of requests per second, shared by Flickr API calls and photo downloads.")
+
+	flag.Parse()
+
+	if *incremental {
+
+		err := stores.RequireReadable(*storage_uri, "-incremental")
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *resume {
+
+		err := stores.RequireReadable(*storage_uri, "-resume")
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *timeout > 0 {
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	ts, err := flickr.NewTokenStore(*tokens)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	creds, err := ts.Get(*account)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	api, err := flickr.NewFlickrAuthAPIWithCredentials(creds)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// one throttle for both API calls and downloads
+
+	t, err := throttle.NewTokenBucket(*rate, 1)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	auth_api, ok := api.(*flickr.FlickrAuthAPI)
+
+	if ok {
+		auth_api.Throttle = t
+	}
+
+	name := *username
+
+	if name == "" {
+		name = creds.Username
+	}
+
+	u, err := user.NewArchiveUserForUsername(api, name)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := stores.NewStore(*storage_uri)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts, err := archivist.DefaultStaticArchivistOptions()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	labels := opts.Sizes.Labels
+
+	if len(sizes) > 0 {
+		labels = sizes
+	}
+
+	policy, err := archivist.NewSizePolicy(*sizes_mode, labels...)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.Sizes = policy
+
+	opts.ArchiveInfo = *archive_info
+	opts.ArchiveSizes = *archive_sizes
+	opts.ArchiveEXIF = *archive_exif
+	opts.ArchiveComments = *archive_comments
+	opts.ContinueOnError = *continue_on_error
+	opts.Incremental = *incremental
+
+	opts.Throttle = t
+
+	layout, err := archivist.NewLayout(*layout_name)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.Layout = layout
+
+	arch, err := archivist.NewStaticArchivist(store, opts)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	query := url.Values{}
+	query.Set("user_id", u.ID())
+
+	if *extras {
+		flickr.AppendExtras(query, flickr.ARCHIVE_EXTRAS...)
+		flickr.AppendExtras(query, flickr.URLExtrasForSizes(opts.Sizes.Labels...)...)
+	}
+
+	// only this user's checkpoints, so that starting over doesn't throw
+	// away the progress of other users in the same archive
+
+	cp, err := checkpoint.NewStoreCheckpointsForQuery(store, "flickr.photos.search", query)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !*resume {
+
+		err = cp.Clear()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	loc, err := time.LoadLocation(*timezone)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	search_opts := common.DefaultAdaptiveSearchOptions()
+	search_opts.Axis = *axis
+	search_opts.Location = loc
+	search_opts.Checkpoints = cp
+
+	t1 := time.Now()
+
+	err = common.ArchivePhotosForUserWithOptions(ctx, arch, api, u, query, search_opts)
+
+	if err == nil {
+		err = cp.Clear()
+	}
+
+	close_err := stores.Close(store)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if close_err != nil {
+		log.Fatal(close_err)
+	}
+
+	log.Printf("Archived photos for %s (%s) in %v\n", u.Username(), u.ID(), time.Since(t1))
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aaronland/go-flickr-archive/archivist"
+	"github.com/aaronland/go-flickr-archive/flickr"
+	"github.com/aaronland/go-flickr-archive/photo"
+	"github.com/aaronland/go-flickr-archive/stores"
+	"github.com/aaronland/go-flickr-archive/throttle"
+	"github.com/whosonfirst/go-whosonfirst-cli/flags"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"syscall"
+)
+
+func main() {
+
+	default_tokens, err := flickr.DefaultTokenStorePath()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var storage_uri = flag.String("storage", "", "A URI for the archive to verify. Valid schemes are: fs://, mem:// and s3://. Anything else is treated as a path on the local filesystem.")
+
+	var verbose = flag.Bool("verbose", false, "Report every file checked, not just the ones with problems.")
+
+	var refetch = flag.Bool("refetch", false, "Archive photos with missing, truncated or corrupted files (or no manifest) again, in the directory that their files are already in.")
+
+	var account = flag.String("account", "", "The name of the account whose OAuth credentials should be used to refetch photos (see flickr-archive-auth).")
+	var tokens = flag.String("tokens", default_tokens, "The path to the file where OAuth credentials are stored.")
+
+	var sizes flags.MultiString
+	flag.Var(&sizes, "size", "A Flickr size label (for example 'Original' or 'Large') to refetch. May be passed multiple times, in order of preference. Default is the largest size available.")
+
+	var sizes_mode = flag.String("sizes-mode", archivist.SIZES_MODE_FIRST, "Whether to refetch the 'first' of the -size labels that exists or 'all' of them.")
+
+	var archive_sizes = flag.Bool("archive-sizes", false, "Archive the response from flickr.photos.getSizes for each refetched photo.")
+	var archive_exif = flag.Bool("archive-exif", false, "Archive the response from flickr.photos.getExif for each refetched photo.")
+	var archive_comments = flag.Bool("archive-comments", false, "Archive the response from flickr.photos.comments.getList for each refetched photo.")
+
+	var rate = flag.Float64("rate", 10, "The maximum number of requests per second, shared by Flickr API calls and photo downloads.")
+
+	flag.Parse()
+
+	err = stores.RequireReadable(*storage_uri, "flickr-archive-verify")
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := stores.NewStore(*storage_uri)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+
+	// the directory that each photo with problems is in, which is where
+	// it gets refetched to, whatever layout the archive is in
+
+	bad := make(map[int64]string)
+
+	cb := func(r *archivist.FixityReport) error {
+
+		counts[r.Status] += 1
+
+		if r.OK() {
+
+			if *verbose {
+				fmt.Printf("%s %s\n", r.Status, r.Key)
+			}
+
+			return nil
+		}
+
+		if r.Error != "" {
+			fmt.Printf("%s %s (%s)\n", r.Status, r.Key, r.Error)
+		} else {
+			fmt.Printf("%s %s\n", r.Status, r.Key)
+		}
+
+		_, seen := bad[r.ID]
+
+		if r.ID != 0 && !seen {
+			bad[r.ID] = path.Dir(r.Key)
+		}
+
+		return nil
+	}
+
+	err = archivist.Verify(ctx, store, cb)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	statuses := make([]string, 0)
+
+	for s := range counts {
+		statuses = append(statuses, s)
+	}
+
+	sort.Strings(statuses)
+
+	for _, s := range statuses {
+		log.Printf("%s: %d\n", s, counts[s])
+	}
+
+	if len(bad) == 0 {
+		return
+	}
+
+	if !*refetch {
+		log.Fatalf("%d photos have problems, use -refetch to archive them again\n", len(bad))
+	}
+
+	api, err := flickr.NewFlickrAuthAPIForAccount(*tokens, *account)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// one throttle for both API calls and downloads
+
+	t, err := throttle.NewTokenBucket(*rate, 1)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	auth_api, ok := api.(*flickr.FlickrAuthAPI)
+
+	if ok {
+		auth_api.Throttle = t
+	}
+
+	opts, err := archivist.DefaultStaticArchivistOptions()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	labels := opts.Sizes.Labels
+
+	if len(sizes) > 0 {
+		labels = sizes
+	}
+
+	policy, err := archivist.NewSizePolicy(*sizes_mode, labels...)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.Sizes = policy
+
+	opts.ArchiveSizes = *archive_sizes
+	opts.ArchiveEXIF = *archive_exif
+	opts.ArchiveComments = *archive_comments
+
+	opts.Throttle = t
+
+	layout_func := func(ph *photo.FlickrPhoto) (string, error) {
+
+		dir, ok := bad[ph.ID]
+
+		if !ok {
+			msg := fmt.Sprintf("Photo %d has no problems to refetch", ph.ID)
+			return "", errors.New(msg)
+		}
+
+		return dir, nil
+	}
+
+	opts.Layout = archivist.NewLayoutWithFunc(layout_func)
+
+	arch, err := archivist.NewStaticArchivist(store, opts)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ids := make([]int64, 0)
+
+	for id := range bad {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	remaining := 0
+
+	for _, id := range ids {
+
+		ph, err := photo.NewFlickrPhoto(id)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = arch.ArchivePhoto(ctx, api, ph)
+
+		if ctx.Err() != nil {
+			log.Fatal(ctx.Err())
+		}
+
+		if err != nil {
+			log.Printf("Failed to refetch photo %d: %s\n", id, err)
+			remaining += 1
+		}
+	}
+
+	err = stores.Close(store)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Refetched %d photos, %d still failing\n", len(ids), remaining)
+
+	if remaining > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,486 @@
+package common
+
+// https://www.flickr.com/services/api/flickr.photos.search.html
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-flickr-archive"
+	"github.com/aaronland/go-flickr-archive/checkpoint"
+	"github.com/aaronland/go-flickr-archive/flickr"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// flickr.photos.search will only ever return (about) this many results for
+// any one query no matter how many pages there are
+
+const SEARCH_RESULTS_CAP int = 4000
+
+const AXIS_UPLOAD_DATE string = "upload"
+
+const AXIS_TAKEN_DATE string = "taken"
+
+// nothing was uploaded to Flickr before this
+
+var FLICKR_EPOCH = time.Date(2004, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// the MySQL DATETIME format that Flickr uses for dates taken
+
+const MYSQL_DATETIME string = "2006-01-02 15:04:05"
+
+// Window is a half-open [Start, End) date range. Flickr's min_ and max_ date
+// parameters are both inclusive so End is turned in to End - 1 second when
+// the window is applied to a query.
+
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+func NewWindow(start time.Time, end time.Time) (*Window, error) {
+
+	if !end.After(start) {
+		return nil, errors.New("Window end must be after window start")
+	}
+
+	w := Window{
+		Start: start.Truncate(time.Second),
+		End:   end.Truncate(time.Second),
+	}
+
+	return &w, nil
+}
+
+func (w *Window) Duration() time.Duration {
+	return w.End.Sub(w.Start)
+}
+
+func (w *Window) String() string {
+	return fmt.Sprintf("%s - %s", w.Start.Format(time.RFC3339), w.End.Format(time.RFC3339))
+}
+
+// NewDayWindow returns the window for the calendar day that dt falls on in
+// loc, which is not always 24 hours long if loc has daylight saving time.
+
+func NewDayWindow(dt time.Time, loc *time.Location) *Window {
+
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	y, m, d := dt.In(loc).Date()
+
+	w := Window{
+		Start: startOfDay(y, m, d, loc),
+		End:   startOfDay(y, m, d+1, loc),
+	}
+
+	return &w
+}
+
+// startOfDay returns the first instant of a day in loc, which isn't midnight
+// when the clocks go forward at midnight.
+
+func startOfDay(y int, m time.Month, d int, loc *time.Location) time.Time {
+
+	t := time.Date(y, m, d, 0, 0, 0, 0, loc)
+
+	// time.Date picks one side of the change for a midnight that doesn't
+	// happen and if it's the earlier one t is still the day before
+
+	if t.Day() != time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Day() {
+		_, t = t.ZoneBounds()
+	}
+
+	return t
+}
+
+// NewWallClockDayWindow returns the window for the calendar day that dt falls
+// on in loc as wall-clock times (see WallClock), which is always 24 hours
+// long and always starts at midnight, even on days when midnight doesn't
+// happen in loc.
+
+func NewWallClockDayWindow(dt time.Time, loc *time.Location) *Window {
+
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	y, m, d := dt.In(loc).Date()
+
+	w := Window{
+		Start: time.Date(y, m, d, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC),
+	}
+
+	return &w
+}
+
+// WallClock returns what t's clock reads, as a time in UTC. Dates taken are
+// wall-clock times without a timezone so windows on that axis are kept as
+// wall-clock times, where every hour happens exactly once and windows that
+// are next to each other always meet, rather than as instants which skip or
+// repeat an hour when the clocks change.
+
+func WallClock(t time.Time) time.Time {
+
+	y, m, d := t.Date()
+	return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// WallClock returns a copy of w in wall-clock times. Windows that are already
+// in UTC are returned as they are.
+
+func (w *Window) WallClock() *Window {
+
+	if w.Start.Location() == time.UTC && w.End.Location() == time.UTC {
+		return w
+	}
+
+	w2 := Window{
+		Start: WallClock(w.Start),
+		End:   WallClock(w.End),
+	}
+
+	return &w2
+}
+
+// Split returns the two halves of w, or false if w is too small to split.
+
+func (w *Window) Split() (*Window, *Window, bool) {
+
+	half := (w.Duration() / 2).Truncate(time.Second)
+
+	if half < time.Second {
+		return nil, nil, false
+	}
+
+	mid := w.Start.Add(half)
+
+	a := Window{Start: w.Start, End: mid}
+	b := Window{Start: mid, End: w.End}
+
+	return &a, &b, true
+}
+
+// Apply sets the min_ and max_ date parameters for axis in query. Upload
+// dates are Unix timestamps so they mean the same thing everywhere. Taken
+// dates don't have a timezone so they are the wall-clock times of w.
+
+func (w *Window) Apply(query url.Values, axis string) error {
+
+	switch axis {
+	case AXIS_UPLOAD_DATE:
+		last := w.End.Add(-1 * time.Second)
+		query.Set("min_upload_date", strconv.FormatInt(w.Start.Unix(), 10))
+		query.Set("max_upload_date", strconv.FormatInt(last.Unix(), 10))
+	case AXIS_TAKEN_DATE:
+		wc := w.WallClock()
+		last := wc.End.Add(-1 * time.Second)
+		query.Set("min_taken_date", wc.Start.Format(MYSQL_DATETIME))
+		query.Set("max_taken_date", last.Format(MYSQL_DATETIME))
+	default:
+		msg := fmt.Sprintf("Invalid date axis '%s'", axis)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+// PhotoSet is a thread-safe set of photo IDs.
+
+type PhotoSet struct {
+	ids map[int64]bool
+	mu  *sync.Mutex
+}
+
+func NewPhotoSet() *PhotoSet {
+
+	s := PhotoSet{
+		ids: make(map[int64]bool),
+		mu:  new(sync.Mutex),
+	}
+
+	return &s
+}
+
+// Add adds id to the set and returns false if it was already there.
+
+func (s *PhotoSet) Add(id int64) bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ids[id] {
+		return false
+	}
+
+	s.ids[id] = true
+	return true
+}
+
+func (s *PhotoSet) Len() int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.ids)
+}
+
+type AdaptiveSearchOptions struct {
+	Axis string
+	Cap  int
+	// the timezone that days are worked out in; taken date windows are
+	// always wall-clock times (see WallClock) and upload dates are always
+	// Unix timestamps
+	Location *time.Location
+	// the size of the first window for sliding searches
+	InitialWindow time.Duration
+	// the largest a window for sliding searches is allowed to grow
+	MaxWindow time.Duration
+	// if not nil, where progress is recorded and resumed from
+	Checkpoints checkpoint.Checkpoints
+}
+
+func DefaultAdaptiveSearchOptions() *AdaptiveSearchOptions {
+
+	opts := AdaptiveSearchOptions{
+		Axis:          AXIS_UPLOAD_DATE,
+		Cap:           SEARCH_RESULTS_CAP,
+		Location:      time.UTC,
+		InitialWindow: 30 * 24 * time.Hour,
+		MaxWindow:     366 * 24 * time.Hour,
+	}
+
+	return &opts
+}
+
+// ArchivePhotosWithAdaptiveSearch archives every photo matching query in w by
+// recursively splitting w in half until each slice has no more than
+// opts.Cap results.
+
+func ArchivePhotosWithAdaptiveSearch(ctx context.Context, arch archive.Archivist, api flickr.API, query url.Values, w *Window, opts *AdaptiveSearchOptions) error {
+
+	seen := NewPhotoSet()
+
+	if opts.Axis == AXIS_TAKEN_DATE {
+		w = w.WallClock()
+	}
+
+	return archivePhotosWithAdaptiveSearch(ctx, arch, api, query, w, opts, seen)
+}
+
+func archivePhotosWithAdaptiveSearch(ctx context.Context, arch archive.Archivist, api flickr.API, query url.Values, w *Window, opts *AdaptiveSearchOptions, seen *PhotoSet) error {
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		// pass
+	}
+
+	total, err := CountSearchResults(ctx, api, query, w, opts.Axis)
+
+	if err != nil {
+		return err
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	if total > opts.Cap {
+
+		a, b, ok := w.Split()
+
+		if ok {
+
+			err := archivePhotosWithAdaptiveSearch(ctx, arch, api, query, a, opts, seen)
+
+			if err != nil {
+				return err
+			}
+
+			return archivePhotosWithAdaptiveSearch(ctx, arch, api, query, b, opts, seen)
+		}
+
+		log.Printf("Window %s has %d results but can not be split any further, some photos will be missed\n", w, total)
+	}
+
+	window_query := cloneQuery(query)
+
+	err = w.Apply(window_query, opts.Axis)
+
+	if err != nil {
+		return err
+	}
+
+	return archivePhotosWithSPR(ctx, arch, api, "flickr.photos.search", window_query, seen, opts.Checkpoints)
+}
+
+// ArchivePhotosWithSlidingSearch archives every photo matching query in w by
+// walking forward through w in windows that shrink when they have more than
+// opts.Cap results and grow (up to opts.MaxWindow) when they have fewer than
+// half that. This is better suited than ArchivePhotosWithAdaptiveSearch to
+// long date ranges where most of the results are bunched together.
+
+func ArchivePhotosWithSlidingSearch(ctx context.Context, arch archive.Archivist, api flickr.API, query url.Values, w *Window, opts *AdaptiveSearchOptions) error {
+
+	seen := NewPhotoSet()
+
+	if opts.Axis == AXIS_TAKEN_DATE {
+		w = w.WallClock()
+	}
+
+	size := opts.InitialWindow
+
+	if size < time.Second {
+		size = time.Second
+	}
+
+	start := w.Start
+
+	// the name of this search for checkpoints, which doesn't include w.End
+	// so that a search can be resumed with a later end date
+
+	name := fmt.Sprintf("sliding:%s:%d", opts.Axis, w.Start.Unix())
+
+	if opts.Checkpoints != nil {
+
+		c, err := opts.Checkpoints.Get(name, query)
+
+		if err != nil {
+			return err
+		}
+
+		if c != nil && c.End > c.Start {
+			start = time.Unix(c.Start, 0).In(w.Start.Location())
+			size = time.Duration(c.End-c.Start) * time.Second
+		}
+	}
+
+	for start.Before(w.End) {
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// pass
+		}
+
+		end := start.Add(size)
+
+		if end.After(w.End) {
+			end = w.End
+		}
+
+		slice := &Window{Start: start, End: end}
+
+		total, err := CountSearchResults(ctx, api, query, slice, opts.Axis)
+
+		if err != nil {
+			return err
+		}
+
+		if total > opts.Cap {
+
+			half := (slice.Duration() / 2).Truncate(time.Second)
+
+			if half >= time.Second {
+				size = half
+				continue
+			}
+
+			log.Printf("Window %s has %d results but can not be split any further, some photos will be missed\n", slice, total)
+		}
+
+		if total > 0 {
+
+			slice_query := cloneQuery(query)
+
+			err := slice.Apply(slice_query, opts.Axis)
+
+			if err != nil {
+				return err
+			}
+
+			err = archivePhotosWithSPR(ctx, arch, api, "flickr.photos.search", slice_query, seen, opts.Checkpoints)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		start = end
+
+		if total < opts.Cap/2 {
+
+			size = size * 2
+
+			if opts.MaxWindow > 0 && size > opts.MaxWindow {
+				size = opts.MaxWindow
+			}
+		}
+
+		if opts.Checkpoints != nil {
+
+			c := checkpoint.NewCheckpoint(name, query)
+			c.Start = start.Unix()
+			c.End = start.Add(size).Unix()
+
+			err := opts.Checkpoints.Record(c)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// CountSearchResults returns the total number of results for query in w.
+
+func CountSearchResults(ctx context.Context, api flickr.API, query url.Values, w *Window, axis string) (int, error) {
+
+	count_query := cloneQuery(query)
+
+	err := w.Apply(count_query, axis)
+
+	if err != nil {
+		return 0, err
+	}
+
+	count_query.Set("per_page", "1")
+	count_query.Set("page", "1")
+
+	body, err := api.ExecuteMethodWithContext(ctx, "flickr.photos.search", count_query)
+
+	if err != nil {
+		return 0, err
+	}
+
+	spr, err := flickr.NewStandardPhotoResponse(body)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return spr.Total(), nil
+}
+
+func cloneQuery(query url.Values) url.Values {
+
+	clone := url.Values{}
+
+	for k, v := range query {
+		clone[k] = append([]string{}, v...)
+	}
+
+	return clone
+}
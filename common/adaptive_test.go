@@ -0,0 +1,698 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aaronland/go-flickr-archive/flickr"
+	"github.com/aaronland/go-flickr-archive/photo"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testAPI answers flickr.photos.search for a fixed set of photos, filtering
+// them on min_ and max_taken_date (MySQL datetimes) or min_ and
+// max_upload_date (Unix timestamps) the way Flickr does, both inclusive, and
+// counts how many times each photo is returned by a search that is being
+// archived.
+
+type testAPI struct {
+	flickr.API
+	taken    map[int64]string
+	uploaded map[int64]int64
+	returned map[int64]int
+	mu       *sync.Mutex
+}
+
+func newTestAPI(taken map[int64]string) *testAPI {
+
+	api := testAPI{
+		taken:    taken,
+		returned: make(map[int64]int),
+		mu:       new(sync.Mutex),
+	}
+
+	return &api
+}
+
+func newUploadTestAPI(uploaded map[int64]int64) *testAPI {
+
+	api := newTestAPI(map[int64]string{})
+	api.uploaded = uploaded
+
+	return api
+}
+
+func (api *testAPI) search(params url.Values) []flickr.StandardPhotoResponsePhoto {
+
+	if api.uploaded != nil {
+		return api.searchUploaded(params)
+	}
+
+	min_date := params.Get("min_taken_date")
+	max_date := params.Get("max_taken_date")
+
+	photos := make([]flickr.StandardPhotoResponsePhoto, 0)
+
+	for id, taken := range api.taken {
+
+		if taken < min_date || taken > max_date {
+			continue
+		}
+
+		ph := flickr.StandardPhotoResponsePhoto{
+			ID: flickr.FlexInt(id),
+		}
+
+		photos = append(photos, ph)
+	}
+
+	return photos
+}
+
+func (api *testAPI) searchUploaded(params url.Values) []flickr.StandardPhotoResponsePhoto {
+
+	min_date, _ := strconv.ParseInt(params.Get("min_upload_date"), 10, 64)
+	max_date, _ := strconv.ParseInt(params.Get("max_upload_date"), 10, 64)
+
+	photos := make([]flickr.StandardPhotoResponsePhoto, 0)
+
+	for id, uploaded := range api.uploaded {
+
+		if uploaded < min_date || uploaded > max_date {
+			continue
+		}
+
+		ph := flickr.StandardPhotoResponsePhoto{
+			ID: flickr.FlexInt(id),
+		}
+
+		photos = append(photos, ph)
+	}
+
+	return photos
+}
+
+func (api *testAPI) response(photos []flickr.StandardPhotoResponsePhoto, total int) ([]byte, error) {
+
+	spr := flickr.StandardPhotoResponse{
+		Photos: flickr.StandardPhotoResponsePhotos{
+			Page:    1,
+			Pages:   1,
+			PerPage: flickr.FlexInt(len(photos)),
+			Total:   flickr.FlexInt(total),
+			Photos:  photos,
+		},
+		Stat: "ok",
+	}
+
+	return json.Marshal(spr)
+}
+
+func (api *testAPI) ExecuteMethodWithContext(ctx context.Context, method string, params url.Values) ([]byte, error) {
+
+	photos := api.search(params)
+	total := len(photos)
+
+	per_page, err := strconv.Atoi(params.Get("per_page"))
+
+	if err == nil && per_page < len(photos) {
+		photos = photos[:per_page]
+	}
+
+	return api.response(photos, total)
+}
+
+func (api *testAPI) ExecuteMethodPaginatedWithContext(ctx context.Context, method string, params url.Values, cb flickr.SPRCallbackFunc) error {
+
+	photos := api.search(params)
+
+	api.mu.Lock()
+
+	for _, ph := range photos {
+		api.returned[int64(ph.ID)] += 1
+	}
+
+	api.mu.Unlock()
+
+	body, err := api.response(photos, len(photos))
+
+	if err != nil {
+		return err
+	}
+
+	spr, err := flickr.NewStandardPhotoResponse(body)
+
+	if err != nil {
+		return err
+	}
+
+	return cb(spr)
+}
+
+type testArchivist struct{}
+
+func (arch *testArchivist) ArchivePhotos(api flickr.API, photos ...photo.Photo) error {
+	return nil
+}
+
+func (arch *testArchivist) ArchivePhotosWithContext(ctx context.Context, api flickr.API, photos ...photo.Photo) error {
+	return nil
+}
+
+func (arch *testArchivist) ArchivePhoto(ctx context.Context, api flickr.API, ph photo.Photo) error {
+	return nil
+}
+
+// takenEvery returns photos taken every step from start (a wall-clock time)
+// up to, but not including, end.
+
+func takenEvery(start string, end string, step time.Duration) (map[int64]string, error) {
+
+	t, err := time.Parse(MYSQL_DATETIME, start)
+
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := time.Parse(MYSQL_DATETIME, end)
+
+	if err != nil {
+		return nil, err
+	}
+
+	taken := make(map[int64]string)
+	id := int64(1)
+
+	for t.Before(last) {
+		taken[id] = t.Format(MYSQL_DATETIME)
+		t = t.Add(step)
+		id += 1
+	}
+
+	return taken, nil
+}
+
+// uploadedEvery returns photos uploaded every step from start up to, but not
+// including, end.
+
+func uploadedEvery(start time.Time, end time.Time, step time.Duration) map[int64]int64 {
+
+	uploaded := make(map[int64]int64)
+	id := int64(1)
+
+	for t := start; t.Before(end); t = t.Add(step) {
+		uploaded[id] = t.Unix()
+		id += 1
+	}
+
+	return uploaded
+}
+
+func checkReturnedOnce(t *testing.T, api *testAPI) {
+
+	for id, taken := range api.taken {
+
+		count := api.returned[id]
+
+		if count == 0 {
+			t.Errorf("Photo %d taken %s was skipped", id, taken)
+		}
+
+		if count > 1 {
+			t.Errorf("Photo %d taken %s was returned %d times", id, taken, count)
+		}
+	}
+
+	for id, uploaded := range api.uploaded {
+
+		count := api.returned[id]
+
+		if count == 0 {
+			t.Errorf("Photo %d uploaded %d was skipped", id, uploaded)
+		}
+
+		if count > 1 {
+			t.Errorf("Photo %d uploaded %d was returned %d times", id, uploaded, count)
+		}
+	}
+}
+
+func loadLocation(t *testing.T, name string) *time.Location {
+
+	loc, err := time.LoadLocation(name)
+
+	if err != nil {
+		t.Skipf("Unable to load %s: %s", name, err)
+	}
+
+	return loc
+}
+
+func applyTaken(t *testing.T, w *Window) (string, string) {
+
+	query := url.Values{}
+
+	err := w.Apply(query, AXIS_TAKEN_DATE)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return query.Get("min_taken_date"), query.Get("max_taken_date")
+}
+
+func TestWallClockDayWindow(t *testing.T) {
+
+	tests := []struct {
+		zone string
+		day  string
+	}{
+		// the clocks go forward at 02:00
+		{"America/New_York", "2024-03-10"},
+		// the clocks go back at 02:00
+		{"America/New_York", "2024-11-03"},
+		// the clocks go forward at midnight, so there isn't one
+		{"America/Santiago", "2024-09-08"},
+	}
+
+	for _, test := range tests {
+
+		loc := loadLocation(t, test.zone)
+
+		dt, err := time.ParseInLocation("2006-01-02 15:04", test.day+" 12:00", loc)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := NewWallClockDayWindow(dt, loc)
+
+		if w.Duration() != 24*time.Hour {
+			t.Errorf("%s in %s is %s long", test.day, test.zone, w.Duration())
+		}
+
+		min_date, max_date := applyTaken(t, w)
+
+		if min_date != test.day+" 00:00:00" {
+			t.Errorf("%s in %s starts at %s", test.day, test.zone, min_date)
+		}
+
+		if max_date != test.day+" 23:59:59" {
+			t.Errorf("%s in %s ends at %s", test.day, test.zone, max_date)
+		}
+	}
+}
+
+func applyUploaded(t *testing.T, w *Window) (int64, int64) {
+
+	query := url.Values{}
+
+	err := w.Apply(query, AXIS_UPLOAD_DATE)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	min_date, err := strconv.ParseInt(query.Get("min_upload_date"), 10, 64)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	max_date, err := strconv.ParseInt(query.Get("max_upload_date"), 10, 64)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return min_date, max_date
+}
+
+func TestWallClock(t *testing.T) {
+
+	loc := loadLocation(t, "America/New_York")
+
+	// the day the clocks go forward is 23 hours long as instants
+
+	start := time.Date(2024, 3, 10, 0, 0, 0, 0, loc)
+	end := time.Date(2024, 3, 11, 0, 0, 0, 0, loc)
+
+	w, err := NewWindow(start, end)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Duration() != 23*time.Hour {
+		t.Fatalf("Expected a 23 hour window but got %s", w.Duration())
+	}
+
+	wc := w.WallClock()
+
+	if wc.Duration() != 24*time.Hour {
+		t.Errorf("Expected a 24 hour wall-clock window but got %s", wc.Duration())
+	}
+
+	min_date, max_date := applyTaken(t, w)
+
+	if min_date != "2024-03-10 00:00:00" || max_date != "2024-03-10 23:59:59" {
+		t.Errorf("Expected 2024-03-10 00:00:00 - 2024-03-10 23:59:59 but got %s - %s", min_date, max_date)
+	}
+}
+
+func TestSplitTakenBoundaries(t *testing.T) {
+
+	tests := []struct {
+		zone  string
+		start time.Time
+		end   time.Time
+	}{
+		{"America/New_York", time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)},
+		{"America/New_York", time.Date(2024, 11, 3, 0, 0, 0, 0, time.UTC), time.Date(2024, 11, 4, 0, 0, 0, 0, time.UTC)},
+		{"America/Santiago", time.Date(2024, 9, 7, 0, 0, 0, 0, time.UTC), time.Date(2024, 9, 9, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+
+		loc := loadLocation(t, test.zone)
+
+		// the windows that the commands used to start from, as instants
+		// in loc
+
+		y, m, d := test.start.Date()
+		start := time.Date(y, m, d, 0, 0, 0, 0, loc)
+
+		y, m, d = test.end.Date()
+		end := time.Date(y, m, d, 0, 0, 0, 0, loc)
+
+		w, err := NewWindow(start, end)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		windows := []*Window{w.WallClock()}
+
+		// split every window in half six times over
+
+		for i := 0; i < 6; i++ {
+
+			split := make([]*Window, 0)
+
+			for _, w := range windows {
+
+				a, b, ok := w.Split()
+
+				if !ok {
+					t.Fatalf("Unable to split %s", w)
+				}
+
+				split = append(split, a, b)
+			}
+
+			windows = split
+		}
+
+		first_min, _ := applyTaken(t, windows[0])
+		_, last_max := applyTaken(t, windows[len(windows)-1])
+
+		if first_min != test.start.Format(MYSQL_DATETIME) {
+			t.Errorf("%s: first window starts at %s", test.zone, first_min)
+		}
+
+		if last_max != test.end.Add(-1*time.Second).Format(MYSQL_DATETIME) {
+			t.Errorf("%s: last window ends at %s", test.zone, last_max)
+		}
+
+		for i := 1; i < len(windows); i++ {
+
+			_, prev_max := applyTaken(t, windows[i-1])
+			next_min, _ := applyTaken(t, windows[i])
+
+			prev, err := time.Parse(MYSQL_DATETIME, prev_max)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if prev.Add(time.Second).Format(MYSQL_DATETIME) != next_min {
+				t.Errorf("%s: window ending %s is followed by one starting %s", test.zone, prev_max, next_min)
+			}
+		}
+	}
+}
+
+func TestAdaptiveSearchTakenBoundaries(t *testing.T) {
+
+	tests := []struct {
+		zone  string
+		start string
+		end   string
+	}{
+		{"America/New_York", "2024-03-10 00:00:00", "2024-03-11 00:00:00"},
+		{"America/New_York", "2024-11-03 00:00:00", "2024-11-04 00:00:00"},
+		{"America/Santiago", "2024-09-07 00:00:00", "2024-09-09 00:00:00"},
+	}
+
+	for _, test := range tests {
+
+		loc := loadLocation(t, test.zone)
+
+		// a photo every 7 minutes, including the hours that don't
+		// happen (or happen twice) in loc since cameras don't know
+
+		taken, err := takenEvery(test.start, test.end, 7*time.Minute)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start, err := time.ParseInLocation(MYSQL_DATETIME, test.start, loc)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		end, err := time.ParseInLocation(MYSQL_DATETIME, test.end, loc)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w, err := NewWindow(start, end)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts := DefaultAdaptiveSearchOptions()
+		opts.Axis = AXIS_TAKEN_DATE
+		opts.Location = loc
+		opts.Cap = 10
+
+		t.Run(fmt.Sprintf("adaptive %s %s", test.zone, test.start), func(t *testing.T) {
+
+			api := newTestAPI(taken)
+
+			err := ArchivePhotosWithAdaptiveSearch(context.Background(), &testArchivist{}, api, url.Values{}, w, opts)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkReturnedOnce(t, api)
+		})
+
+		t.Run(fmt.Sprintf("sliding %s %s", test.zone, test.start), func(t *testing.T) {
+
+			api := newTestAPI(taken)
+
+			sliding_opts := *opts
+			sliding_opts.InitialWindow = 37 * time.Minute
+
+			err := ArchivePhotosWithSlidingSearch(context.Background(), &testArchivist{}, api, url.Values{}, w, &sliding_opts)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkReturnedOnce(t, api)
+		})
+	}
+}
+
+func TestSearchForDayTaken(t *testing.T) {
+
+	loc := loadLocation(t, "America/Santiago")
+
+	taken, err := takenEvery("2024-09-07 23:00:00", "2024-09-09 01:00:00", 13*time.Minute)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := newTestAPI(taken)
+
+	opts := DefaultAdaptiveSearchOptions()
+	opts.Axis = AXIS_TAKEN_DATE
+	opts.Location = loc
+
+	dt := time.Date(2024, 9, 8, 12, 0, 0, 0, loc)
+
+	err = ArchivePhotosWithSearchForDayWithOptions(context.Background(), &testArchivist{}, api, url.Values{}, dt, opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, taken := range api.taken {
+
+		on_day := taken >= "2024-09-08 00:00:00" && taken <= "2024-09-08 23:59:59"
+		count := api.returned[id]
+
+		if on_day && count != 1 {
+			t.Errorf("Photo %d taken %s was returned %d times", id, taken, count)
+		}
+
+		if !on_day && count != 0 {
+			t.Errorf("Photo %d taken %s is not on 2024-09-08 but was returned", id, taken)
+		}
+	}
+}
+
+func TestDayWindowUploaded(t *testing.T) {
+
+	tests := []struct {
+		zone  string
+		day   string
+		hours time.Duration
+	}{
+		{"America/New_York", "2024-03-10", 23},
+		{"America/New_York", "2024-11-03", 25},
+		{"America/Santiago", "2024-09-08", 23},
+	}
+
+	for _, test := range tests {
+
+		loc := loadLocation(t, test.zone)
+
+		dt, err := time.ParseInLocation("2006-01-02 15:04", test.day+" 12:00", loc)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// upload dates are instants so the day is however long it
+		// actually was in loc
+
+		w := NewDayWindow(dt, loc)
+
+		if w.Duration() != test.hours*time.Hour {
+			t.Errorf("%s in %s is %s long", test.day, test.zone, w.Duration())
+		}
+
+		min_date, max_date := applyUploaded(t, w)
+
+		// the first and last seconds of the day in loc, which don't
+		// have to be midnight
+
+		day := func(ts int64) string {
+			return time.Unix(ts, 0).In(loc).Format("2006-01-02")
+		}
+
+		if day(min_date) != test.day || day(min_date-1) == test.day {
+			t.Errorf("%s in %s starts at %s", test.day, test.zone, time.Unix(min_date, 0).In(loc))
+		}
+
+		if day(max_date) != test.day || day(max_date+1) == test.day {
+			t.Errorf("%s in %s ends at %s", test.day, test.zone, time.Unix(max_date, 0).In(loc))
+		}
+	}
+}
+
+func TestAdaptiveSearchUploadBoundaries(t *testing.T) {
+
+	loc := loadLocation(t, "America/New_York")
+
+	// the night the clocks go back, and the 25 hour day after it
+
+	start := time.Date(2024, 11, 2, 12, 0, 0, 0, loc)
+	end := time.Date(2024, 11, 4, 0, 0, 0, 0, loc)
+
+	w, err := NewWindow(start, end)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a photo every 7 minutes, plus one on either side of the window
+
+	uploaded := uploadedEvery(start, end, 7*time.Minute)
+
+	before := int64(len(uploaded) + 1)
+	after := before + 1
+
+	opts := DefaultAdaptiveSearchOptions()
+	opts.Axis = AXIS_UPLOAD_DATE
+	opts.Location = loc
+	opts.Cap = 10
+
+	check := func(t *testing.T, api *testAPI) {
+
+		if api.returned[before] != 0 || api.returned[after] != 0 {
+			t.Errorf("Photos uploaded outside %s were returned", w)
+		}
+
+		delete(api.uploaded, before)
+		delete(api.uploaded, after)
+
+		checkReturnedOnce(t, api)
+	}
+
+	newAPI := func() *testAPI {
+
+		api_uploaded := make(map[int64]int64)
+
+		for id, u := range uploaded {
+			api_uploaded[id] = u
+		}
+
+		api_uploaded[before] = start.Unix() - 1
+		api_uploaded[after] = end.Unix()
+
+		return newUploadTestAPI(api_uploaded)
+	}
+
+	t.Run("adaptive", func(t *testing.T) {
+
+		api := newAPI()
+
+		err := ArchivePhotosWithAdaptiveSearch(context.Background(), &testArchivist{}, api, url.Values{}, w, opts)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		check(t, api)
+	})
+
+	t.Run("sliding", func(t *testing.T) {
+
+		api := newAPI()
+
+		sliding_opts := *opts
+		sliding_opts.InitialWindow = 37 * time.Minute
+
+		err := ArchivePhotosWithSlidingSearch(context.Background(), &testArchivist{}, api, url.Values{}, w, &sliding_opts)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		check(t, api)
+	})
+}
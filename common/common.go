@@ -1,76 +1,182 @@
 package common
 
 import (
-	_ "context"
-	"fmt"
+	"context"
 	"github.com/aaronland/go-flickr-archive"
+	"github.com/aaronland/go-flickr-archive/checkpoint"
 	"github.com/aaronland/go-flickr-archive/flickr"
 	"github.com/aaronland/go-flickr-archive/photo"
 	"github.com/aaronland/go-flickr-archive/user"
 	"net/url"
+	"strconv"
 	"time"
 )
 
 func ArchivePhotosForUser(arch archive.Archivist, api flickr.API, u user.User) error {
+	return ArchivePhotosForUserWithContext(context.Background(), arch, api, u)
+}
+
+func ArchivePhotosForUserWithContext(ctx context.Context, arch archive.Archivist, api flickr.API, u user.User) error {
 
 	query := url.Values{}
+	opts := DefaultAdaptiveSearchOptions()
+
+	return ArchivePhotosForUserWithOptions(ctx, arch, api, u, query, opts)
+}
+
+// ArchivePhotosForUserWithOptions archives all of a user's photos, from the
+// date of their first photo (uploaded or taken depending on opts.Axis) until
+// now, using ArchivePhotosWithSlidingSearch. query may contain any other
+// search parameters, like extras.
+
+func ArchivePhotosForUserWithOptions(ctx context.Context, arch archive.Archivist, api flickr.API, u user.User, query url.Values, opts *AdaptiveSearchOptions) error {
+
+	query = cloneQuery(query)
 	query.Set("user_id", u.ID())
 
-	dt := u.DateFirstPhoto()
+	start := u.DateFirstPhoto()
 
-	for {
+	if opts.Axis == AXIS_TAKEN_DATE {
 
-		err := ArchivePhotosWithSearchForDay(arch, api, query, dt)
+		// the first date taken is a wall-clock time, as are the search
+		// windows for dates taken
 
-		if err != nil {
-			return err
-		}
+		start = WallClock(u.DateFirstPhotoTaken())
+	}
 
-		dt = dt.AddDate(0, 0, 1)
-		today := time.Now()
+	// include anything uploaded while we're running but stop at a fixed
+	// time so that a resumed search is the same search
 
-		if dt.After(today) {
-			break
-		}
+	y, m, d := time.Now().UTC().Date()
+	end := time.Date(y, m, d+2, 0, 0, 0, 0, time.UTC)
+
+	w, err := NewWindow(start, end)
+
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return ArchivePhotosWithSlidingSearch(ctx, arch, api, query, w, opts)
 }
 
 func ArchivePhotosWithSearchForDay(arch archive.Archivist, api flickr.API, query url.Values, dt time.Time) error {
+	return ArchivePhotosWithSearchForDayWithContext(context.Background(), arch, api, query, dt)
+}
+
+func ArchivePhotosWithSearchForDayWithContext(ctx context.Context, arch archive.Archivist, api flickr.API, query url.Values, dt time.Time) error {
 
-	// because time.Format() is just so weird...
+	opts := DefaultAdaptiveSearchOptions()
+	return ArchivePhotosWithSearchForDayWithOptions(ctx, arch, api, query, dt, opts)
+}
+
+// ArchivePhotosWithSearchForDayWithOptions archives the photos matching query
+// that were uploaded (or taken, depending on opts.Axis) on the day that dt
+// falls on in opts.Location.
+
+func ArchivePhotosWithSearchForDayWithOptions(ctx context.Context, arch archive.Archivist, api flickr.API, query url.Values, dt time.Time, opts *AdaptiveSearchOptions) error {
+
+	w := NewDayWindow(dt, opts.Location)
 
-	y, m, d := dt.Date()
-	ymd := fmt.Sprintf("%04d-%02d-%02d", y, m, d)
+	if opts.Axis == AXIS_TAKEN_DATE {
+		w = NewWallClockDayWindow(dt, opts.Location)
+	}
+
+	day_query := cloneQuery(query)
 
-	min_date := fmt.Sprintf("%s 00:00:00", ymd)
-	max_date := fmt.Sprintf("%s 23:59:59", ymd)
+	err := w.Apply(day_query, opts.Axis)
 
-	query.Set("min_upload_date", min_date)
-	query.Set("max_upload_date", max_date)
+	if err != nil {
+		return err
+	}
 
-	return ArchivePhotosWithSearch(arch, api, query)
+	return ArchivePhotosWithSearchWithCheckpoints(ctx, arch, api, day_query, opts.Checkpoints)
 }
 
 func ArchivePhotosWithSearch(arch archive.Archivist, api flickr.API, query url.Values) error {
+	return ArchivePhotosWithSearchWithContext(context.Background(), arch, api, query)
+}
+
+func ArchivePhotosWithSearchWithContext(ctx context.Context, arch archive.Archivist, api flickr.API, query url.Values) error {
+
+	method := "flickr.photos.search"
+	return ArchivePhotosWithSPRWithContext(ctx, arch, api, method, query)
+}
+
+// ArchivePhotosWithSearchWithCheckpoints records each page of results that
+// has been archived in cp and, if there is already a checkpoint for query,
+// starts from the page after it.
+
+func ArchivePhotosWithSearchWithCheckpoints(ctx context.Context, arch archive.Archivist, api flickr.API, query url.Values, cp checkpoint.Checkpoints) error {
 
 	method := "flickr.photos.search"
-	return ArchivePhotosWithSPR(arch, api, method, query)
+	return ArchivePhotosWithSPRWithCheckpoints(ctx, arch, api, method, query, cp)
 }
 
 func ArchivePhotosWithSPR(arch archive.Archivist, api flickr.API, method string, query url.Values) error {
+	return ArchivePhotosWithSPRWithContext(context.Background(), arch, api, method, query)
+}
+
+func ArchivePhotosWithSPRWithContext(ctx context.Context, arch archive.Archivist, api flickr.API, method string, query url.Values) error {
+	return archivePhotosWithSPR(ctx, arch, api, method, query, nil, nil)
+}
+
+func ArchivePhotosWithSPRWithCheckpoints(ctx context.Context, arch archive.Archivist, api flickr.API, method string, query url.Values, cp checkpoint.Checkpoints) error {
+	return archivePhotosWithSPR(ctx, arch, api, method, query, nil, cp)
+}
+
+// archivePhotosWithSPR skips any photos that are already in seen, if it's
+// not nil, which is how photos that show up in more than one search are
+// only archived once. If cp isn't nil then pages that have already been
+// archived are skipped and each page is recorded once it's been archived.
+
+func archivePhotosWithSPR(ctx context.Context, arch archive.Archivist, api flickr.API, method string, query url.Values, seen *PhotoSet, cp checkpoint.Checkpoints) error {
+
+	extras := flickr.RequestedExtras(query)
+
+	spr_query := query
+
+	if cp != nil {
+
+		c, err := cp.Get(method, query)
+
+		if err != nil {
+			return err
+		}
+
+		if c != nil && c.Complete() {
+			return nil
+		}
+
+		if c != nil && c.Page > 0 {
+			spr_query = cloneQuery(query)
+			spr_query.Set("page", strconv.Itoa(c.Page+1))
+		}
+	}
+
+	checkpoint_page := func(spr flickr.WIPStandardPhotoResponse) error {
+
+		if cp == nil {
+			return nil
+		}
+
+		c := checkpoint.NewCheckpoint(method, query)
+		c.Page = spr.Page()
+		c.Pages = spr.Pages()
 
-	// ctx, cancel := context.WithCancel(context.Background())
-	// defer cancel()
+		return cp.Record(c)
+	}
 
-	cb := func(spr flickr.StandardPhotoResponse) error {
+	cb := func(spr flickr.WIPStandardPhotoResponse) error {
 
 		photos := make([]photo.Photo, 0)
 
-		for _, spr_ph := range spr.Photos.Photos {
+		for _, spr_ph := range spr.Photos() {
+
+			if seen != nil && !seen.Add(spr_ph.ID()) {
+				continue
+			}
 
-			ph, err := photo.NewFlickrPhotoFromString(spr_ph.ID)
+			ph, err := photo.NewFlickrPhotoFromSPR(spr_ph, extras)
 
 			if err != nil {
 				return err
@@ -79,8 +185,18 @@ func ArchivePhotosWithSPR(arch archive.Archivist, api flickr.API, method string,
 			photos = append(photos, ph)
 		}
 
-		return arch.ArchivePhotos(api, photos...)
+		if len(photos) == 0 {
+			return checkpoint_page(spr)
+		}
+
+		err := arch.ArchivePhotosWithContext(ctx, api, photos...)
+
+		if err != nil {
+			return err
+		}
+
+		return checkpoint_page(spr)
 	}
 
-	return api.ExecuteMethodPaginated(method, query, cb)
+	return api.ExecuteMethodPaginatedWithContext(ctx, method, spr_query, cb)
 }
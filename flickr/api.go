@@ -1,10 +1,11 @@
 package flickr
 
 import (
+	"context"
 	"crypto/md5"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/aaronland/go-flickr-archive/throttle"
 	"github.com/tidwall/gjson"
 	"io/ioutil"
 	_ "log"
@@ -13,25 +14,42 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-type SPRCallbackFunc func(StandardPhotoResponse) error
+type SPRCallbackFunc func(WIPStandardPhotoResponse) error
 
 type FlickrAuthAPI struct {
 	API
-	Key      string
-	Secret   string
+	Key         string
+	Secret      string
+	Token       string
+	TokenSecret string
+	Retry       *RetryPolicy
+	// the number of pages ExecuteMethodPaginated fetches ahead of the page
+	// being processed
+	Prefetch int
+	// shared with anything else (like an archivist) that should count
+	// against the same limit
+	Throttle throttle.Throttle
 	client   *http.Client
-	throttle <-chan time.Time
 }
 
 func NewFlickrAuthAPI(key string, secret string) (API, error) {
+	return NewFlickrAuthAPIWithAccessToken(key, secret, "", "")
+}
+
+// calls made with an access token are signed using OAuth 1.0a which is what
+// you need to do in order to see private photos (and their originals)
 
-	// https://github.com/golang/go/wiki/RateLimiting
+func NewFlickrAuthAPIWithAccessToken(key string, secret string, token string, token_secret string) (API, error) {
 
-	rate := time.Second / 10
-	throttle := time.Tick(rate)
+	t, err := throttle.NewTokenBucket(10, 1)
+
+	if err != nil {
+		return nil, err
+	}
 
 	tr := &http.Transport{
 		MaxIdleConns:    10,
@@ -41,30 +59,71 @@ func NewFlickrAuthAPI(key string, secret string) (API, error) {
 	cl := &http.Client{Transport: tr}
 
 	api := FlickrAuthAPI{
-		Key:      key,
-		Secret:   secret,
-		throttle: throttle,
-		client:   cl,
+		Key:         key,
+		Secret:      secret,
+		Token:       token,
+		TokenSecret: token_secret,
+		Retry:       DefaultRetryPolicy(),
+		Prefetch:    4,
+		Throttle:    t,
+		client:      cl,
 	}
 
 	return &api, nil
 }
 
 func (api *FlickrAuthAPI) ExecuteMethod(method string, params url.Values) ([]byte, error) {
+	return api.ExecuteMethodWithContext(context.Background(), method, params)
+}
 
-	params.Set("method", method)
-	rsp, err := api.Call(params)
+func (api *FlickrAuthAPI) ExecuteMethodWithContext(ctx context.Context, method string, params url.Values) ([]byte, error) {
+
+	var body []byte
+
+	fn := func() error {
+
+		rsp, err := api.executeMethod(ctx, method, params)
+
+		if err != nil {
+			return err
+		}
+
+		body = rsp
+		return nil
+	}
+
+	err := api.Retry.Do(ctx, fn)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if rsp.StatusCode != 200 {
-		return nil, errors.New(rsp.Status)
+	return body, nil
+}
+
+func (api *FlickrAuthAPI) executeMethod(ctx context.Context, method string, params url.Values) ([]byte, error) {
+
+	params.Set("method", method)
+	rsp, err := api.CallWithContext(ctx, params)
+
+	if err != nil {
+		return nil, err
 	}
 
 	defer rsp.Body.Close()
 
+	if rsp.StatusCode != 200 {
+
+		api_err := &APIError{
+			Method:     method,
+			Message:    rsp.Status,
+			StatusCode: rsp.StatusCode,
+			RetryAfter: ParseRetryAfter(rsp),
+		}
+
+		return nil, api_err
+	}
+
 	body, err := ioutil.ReadAll(rsp.Body)
 
 	if err != nil {
@@ -72,8 +131,6 @@ func (api *FlickrAuthAPI) ExecuteMethod(method string, params url.Values) ([]byt
 	}
 
 	// {"stat":"fail","code":96,"message":"Invalid signature"}
-	// PLEASE FOR TO BE MAKING-AND-RETURNING A PACKAGE SPECIFIC
-	// ERROR THINGY (20171204/thisisaaronland)
 
 	stat := gjson.GetBytes(body, "stat")
 
@@ -90,101 +147,225 @@ func (api *FlickrAuthAPI) ExecuteMethod(method string, params url.Values) ([]byt
 			return nil, errors.New("Unable to parse error reponse")
 		}
 
-		msg := fmt.Sprintf("%d %s", errcode, errmsg)
-		return nil, errors.New(msg)
+		api_err := &APIError{
+			Method:     method,
+			Code:       int(errcode.Int()),
+			Message:    errmsg.String(),
+			StatusCode: rsp.StatusCode,
+		}
+
+		return nil, api_err
 	}
 
 	return body, nil
 }
 
 func (api *FlickrAuthAPI) ExecuteMethodPaginated(method string, params url.Values, cb SPRCallbackFunc) error {
+	return api.ExecuteMethodPaginatedWithContext(context.Background(), method, params, cb)
+}
 
-	page := 1
-	pages := 0
+// ExecuteMethodPaginatedWithContext fetches the first page of results and
+// then, once it knows how many pages there are, up to api.Prefetch of the
+// remaining pages at a time while cb is busy with earlier ones. Pages are
+// always passed to cb in order and every request still waits on the throttle.
+// If params has a page parameter then that is the first page fetched, which
+// is how a search is resumed.
 
-	/*
+func (api *FlickrAuthAPI) ExecuteMethodPaginatedWithContext(ctx context.Context, method string, params url.Values, cb SPRCallbackFunc) error {
 
-		done_ch := make(chan bool)
-		ticker := time.NewTicker(time.Second * 5)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		// pass
+	}
 
-		go func() {
+	first := 1
 
-			for range ticker.C {
+	if params.Get("page") != "" {
 
-				select {
-				case <- done_ch:
-					log.Printf("%s (%s) DONE\n", method, params.Get("woe_id"))
-					return
-				default:
-					log.Printf("%s (%s) page %d/%d\n", method, params.Get("woe_id"), page, pages)
-				}
-			}
+		p, err := strconv.Atoi(params.Get("page"))
 
-		}()
+		if err != nil {
+			return err
+		}
 
-		defer func() {
-			done_ch <- true
-		}()
+		if p > 1 {
+			first = p
+		}
+	}
 
-	*/
+	spr, err := api.executePage(ctx, method, params, first)
 
-	for {
+	if err != nil {
+		return err
+	}
 
-		params.Set("page", strconv.Itoa(page))
+	err = cb(spr)
 
-		rsp, err := api.ExecuteMethod(method, params)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			return err
+	pages := spr.Pages()
+
+	if pages <= first {
+		return nil
+	}
+
+	prefetch := api.Prefetch
+
+	if prefetch < 1 {
+		prefetch = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	type pageResult struct {
+		spr WIPStandardPhotoResponse
+		err error
+	}
+
+	results := make([]chan *pageResult, pages+1)
+
+	for p := first + 1; p <= pages; p++ {
+		results[p] = make(chan *pageResult, 1)
+	}
+
+	// a slot is taken when a page starts being fetched and given back
+	// when it's been passed to cb so there are never more than prefetch
+	// pages in memory (or in flight) at once
+
+	slots := make(chan bool, prefetch)
+
+	wg := new(sync.WaitGroup)
+
+	// cancel has to happen before we wait, and deferred calls are run
+	// last-in-first-out
+
+	defer wg.Wait()
+	defer cancel()
+
+	wg.Add(1)
+
+	go func() {
+
+		defer wg.Done()
+
+		for p := first + 1; p <= pages; p++ {
+
+			select {
+			case <-ctx.Done():
+				return
+			case slots <- true:
+				// pass
+			}
+
+			wg.Add(1)
+
+			go func(p int) {
+
+				defer wg.Done()
+
+				spr, err := api.executePage(ctx, method, params, p)
+				results[p] <- &pageResult{spr: spr, err: err}
+			}(p)
 		}
+	}()
 
-		var spr StandardPhotoResponse
+	for p := first + 1; p <= pages; p++ {
 
-		err = json.Unmarshal(rsp, &spr)
+		var r *pageResult
 
-		if err != nil {
-			return err
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case r = <-results[p]:
+			// pass
+		}
+
+		if r.err != nil {
+			return r.err
 		}
 
-		err = cb(spr)
+		err := cb(r.spr)
 
 		if err != nil {
 			return err
 		}
 
-		pages = spr.Photos.Pages
-		page += 1
-
-		if pages == 0 || page > pages {
-			break
-		}
+		<-slots
 	}
 
 	return nil
 }
 
+// executePage fetches one page of results using a copy of params so that it
+// is safe to call concurrently.
+
+func (api *FlickrAuthAPI) executePage(ctx context.Context, method string, params url.Values, page int) (WIPStandardPhotoResponse, error) {
+
+	page_params := url.Values{}
+
+	for k, v := range params {
+		page_params[k] = append([]string{}, v...)
+	}
+
+	page_params.Set("page", strconv.Itoa(page))
+
+	rsp, err := api.ExecuteMethodWithContext(ctx, method, page_params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStandardPhotoResponse(rsp)
+}
+
 func (api FlickrAuthAPI) Call(params url.Values) (*http.Response, error) {
+	return api.CallWithContext(context.Background(), params)
+}
+
+func (api FlickrAuthAPI) CallWithContext(ctx context.Context, params url.Values) (*http.Response, error) {
 
 	params.Set("format", "json")
 	params.Set("nojsoncallback", "1")
-	params.Set("api_key", api.Key)
 
-	// sig := api.Sign(params)
-	// params.Set("api_sig", sig)
+	http_method := "POST"
+	endpoint := "https://api.flickr.com/services/rest/"
+
+	if api.Token != "" {
 
-	url := "https://api.flickr.com/services/rest/"
+		params.Del("api_key")
 
-	req, err := http.NewRequest("POST", url, nil)
+		err := SignOAuthParams(http_method, endpoint, params, api.Key, api.Secret, api.Token, api.TokenSecret)
+
+		if err != nil {
+			return nil, err
+		}
+
+	} else {
+		params.Set("api_key", api.Key)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http_method, endpoint, nil)
 
 	if err != nil {
 		return nil, err
 	}
 
-	// log.Printf("%s?%s\n", url, params.Encode())
+	// log.Printf("%s?%s\n", endpoint, params.Encode())
 
 	req.URL.RawQuery = params.Encode()
 
-	<-api.throttle
+	if api.Throttle != nil {
+
+		err := api.Throttle.Wait(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	rsp, err := api.client.Do(req)
 
@@ -0,0 +1,113 @@
+package flickr
+
+// https://www.flickr.com/services/api/response.json.html
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrPhotoNotFound = errors.New("Photo not found")
+
+var ErrInvalidKey = errors.New("Invalid API key")
+
+var ErrPermissionDenied = errors.New("Permission denied")
+
+var ErrServiceUnavailable = errors.New("Service unavailable")
+
+var ErrRateLimited = errors.New("Rate limited")
+
+const ERROR_CODE_INSUFFICIENT_PERMISSIONS int = 99
+
+const ERROR_CODE_INVALID_KEY int = 100
+
+const ERROR_CODE_SERVICE_UNAVAILABLE int = 105
+
+// APIError is returned by ExecuteMethod both for {"stat":"fail"} responses,
+// in which case Code and Message are populated, and for non-200 HTTP
+// responses where Code is 0 and Message is the HTTP status line.
+
+type APIError struct {
+	Method     string
+	Code       int
+	Message    string
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+
+	if e.Code == 0 {
+		return fmt.Sprintf("%s failed: %s", e.Method, e.Message)
+	}
+
+	return fmt.Sprintf("%s failed: %d %s", e.Method, e.Code, e.Message)
+}
+
+func (e *APIError) Is(target error) bool {
+
+	switch target {
+	case ErrPhotoNotFound:
+		// error code 1 means different things for different methods so
+		// check the message too
+		return e.Code == 1 && strings.EqualFold(e.Message, "Photo not found")
+	case ErrInvalidKey:
+		return e.Code == ERROR_CODE_INVALID_KEY
+	case ErrPermissionDenied:
+		if e.Code == ERROR_CODE_INSUFFICIENT_PERMISSIONS || e.StatusCode == 403 {
+			return true
+		}
+		return e.Code == 2 && strings.EqualFold(e.Message, "Permission denied")
+	case ErrServiceUnavailable:
+		return e.Code == ERROR_CODE_SERVICE_UNAVAILABLE || e.StatusCode == 503
+	case ErrRateLimited:
+		return e.StatusCode == 429
+	default:
+		return false
+	}
+}
+
+// Temporary reports whether the same request might reasonably be expected
+// to succeed if it is tried again later.
+
+func (e *APIError) Temporary() bool {
+
+	if errors.Is(e, ErrServiceUnavailable) || errors.Is(e, ErrRateLimited) {
+		return true
+	}
+
+	return e.StatusCode >= 500
+}
+
+// HTTPError is returned for non-200 responses that aren't API calls, for
+// example when downloading a photo.
+
+type HTTPError struct {
+	URL        string
+	Status     string
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func NewHTTPError(rsp *http.Response) *HTTPError {
+
+	e := HTTPError{
+		URL:        rsp.Request.URL.String(),
+		Status:     rsp.Status,
+		StatusCode: rsp.StatusCode,
+		RetryAfter: ParseRetryAfter(rsp),
+	}
+
+	return &e
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.URL, e.Status)
+}
+
+func (e *HTTPError) Temporary() bool {
+	return e.StatusCode == 429 || e.StatusCode >= 500
+}
@@ -0,0 +1,100 @@
+package flickr
+
+// https://www.flickr.com/services/api/flickr.photos.search.html
+// https://www.flickr.com/services/api/misc.urls.html
+
+import (
+	"net/url"
+	"strings"
+)
+
+// the extras needed to archive a photo without calling flickr.photos.getInfo
+
+var ARCHIVE_EXTRAS = []string{
+	"original_format",
+	"last_update",
+	"media",
+	"o_dims",
+	"date_taken",
+}
+
+var SIZE_SUFFIXES = map[string]string{
+	"Square":       "sq",
+	"Large Square": "q",
+	"Thumbnail":    "t",
+	"Small":        "s",
+	"Small 320":    "n",
+	"Medium":       "m",
+	"Medium 640":   "z",
+	"Medium 800":   "c",
+	"Large":        "l",
+	"Large 1600":   "h",
+	"Large 2048":   "k",
+	"Original":     "o",
+}
+
+// URLExtrasForSizes returns the url_ extras for labels, ignoring any labels
+// that don't have one (like the various video sizes).
+
+func URLExtrasForSizes(labels ...string) []string {
+
+	extras := make([]string, 0)
+
+	for _, label := range labels {
+
+		suffix, ok := SIZE_SUFFIXES[label]
+
+		if ok {
+			extras = append(extras, "url_"+suffix)
+		}
+	}
+
+	return extras
+}
+
+// AppendExtras adds extras to any that are already set in params.
+
+func AppendExtras(params url.Values, extras ...string) {
+
+	current := make([]string, 0)
+
+	if params.Get("extras") != "" {
+		current = strings.Split(params.Get("extras"), ",")
+	}
+
+	seen := make(map[string]bool)
+
+	for _, e := range current {
+		seen[strings.TrimSpace(e)] = true
+	}
+
+	for _, e := range extras {
+
+		if seen[e] {
+			continue
+		}
+
+		seen[e] = true
+		current = append(current, e)
+	}
+
+	params.Set("extras", strings.Join(current, ","))
+}
+
+// RequestedExtras returns the extras set in params.
+
+func RequestedExtras(params url.Values) []string {
+
+	extras := make([]string, 0)
+
+	for _, e := range strings.Split(params.Get("extras"), ",") {
+
+		e = strings.TrimSpace(e)
+
+		if e != "" {
+			extras = append(extras, e)
+		}
+	}
+
+	return extras
+}
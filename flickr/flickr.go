@@ -1,33 +1,88 @@
 package flickr
 
 import (
+	"context"
 	"net/http"
 	"net/url"
 )
 
+// these are the structs that standard photo responses are decoded in to;
+// Flickr is not consistent about whether numbers (and booleans) are encoded
+// as numbers or strings hence all the Flex types
+
 type StandardPhotoResponse struct {
 	Photos StandardPhotoResponsePhotos `json:"photos"`
 	Stat   string                      `json:"stat"`
 }
 
 type StandardPhotoResponsePhotos struct {
-	Page    int                          `json:"page"`
-	Pages   int                          `json:"pages"`
-	PerPage int                          `json:"perpage"`
-	Total   string                       `json:"total"` // see the way this is a string... what???
+	Page    FlexInt                      `json:"page"`
+	Pages   FlexInt                      `json:"pages"`
+	PerPage FlexInt                      `json:"perpage"`
+	Total   FlexInt                      `json:"total"`
 	Photos  []StandardPhotoResponsePhoto `json:"photo"` // see the way its 'photo' and not 'photos' ... yeah, that
 }
 
 type StandardPhotoResponsePhoto struct {
-	ID       string `json:"id"` // string... what??
-	Owner    string `json:"owner"`
-	Secret   string `json:"secret"`
-	Server   string `json:"server"` // string... what??
-	Farm     int    `json:"farm"`
-	Title    string `json:title"`
-	IsPublic int    `json:ispublic"` // Y U NO bool
-	IsFriend int    `json:isfriend"` // see above
-	IsFamily int    `json:isfamily"` // see above
+	ID       FlexInt  `json:"id"`
+	Owner    string   `json:"owner"`
+	Secret   string   `json:"secret"`
+	Server   FlexInt  `json:"server"`
+	Farm     FlexInt  `json:"farm"`
+	Title    string   `json:"title"`
+	IsPublic FlexBool `json:"ispublic"`
+	IsFriend FlexBool `json:"isfriend"`
+	IsFamily FlexBool `json:"isfamily"`
+	// the following are only present if they were requested using the
+	// "extras" parameter
+	OriginalSecret string  `json:"originalsecret,omitempty"`
+	OriginalFormat string  `json:"originalformat,omitempty"`
+	LastUpdate     FlexInt `json:"lastupdate,omitempty"`
+	Media          string  `json:"media,omitempty"`
+	OriginalWidth  FlexInt `json:"o_width,omitempty"`
+	OriginalHeight FlexInt `json:"o_height,omitempty"`
+	DateTaken      string  `json:"datetaken,omitempty"`
+	URLSquare      string  `json:"url_sq,omitempty"`
+	URLLargeSquare string  `json:"url_q,omitempty"`
+	URLThumbnail   string  `json:"url_t,omitempty"`
+	URLSmall       string  `json:"url_s,omitempty"`
+	URLSmall320    string  `json:"url_n,omitempty"`
+	URLMedium      string  `json:"url_m,omitempty"`
+	URLMedium640   string  `json:"url_z,omitempty"`
+	URLMedium800   string  `json:"url_c,omitempty"`
+	URLLarge       string  `json:"url_l,omitempty"`
+	URLLarge1600   string  `json:"url_h,omitempty"`
+	URLLarge2048   string  `json:"url_k,omitempty"`
+	URLOriginal    string  `json:"url_o,omitempty"`
+}
+
+// URLs returns the url_ extras that are present, keyed by size label.
+
+func (ph StandardPhotoResponsePhoto) URLs() map[string]string {
+
+	urls := map[string]string{
+		"Square":       ph.URLSquare,
+		"Large Square": ph.URLLargeSquare,
+		"Thumbnail":    ph.URLThumbnail,
+		"Small":        ph.URLSmall,
+		"Small 320":    ph.URLSmall320,
+		"Medium":       ph.URLMedium,
+		"Medium 640":   ph.URLMedium640,
+		"Medium 800":   ph.URLMedium800,
+		"Large":        ph.URLLarge,
+		"Large 1600":   ph.URLLarge1600,
+		"Large 2048":   ph.URLLarge2048,
+		"Original":     ph.URLOriginal,
+	}
+
+	for label, u := range urls {
+
+		if u == "" {
+			delete(urls, label)
+		}
+	}
+
+	return urls
 }
 
 type WIPStandardPhotoResponse interface {
@@ -47,13 +102,26 @@ type WIPStandardPhoto interface {
 	Title() string
 	IsPublic() bool
 	IsPrivate() bool
+	IsFriend() bool
 	IsFamily() bool
 	PhotoPage() url.URL
 	PhotoURL() url.URL
+	// extras, which will be empty unless they were requested
+	OriginalSecret() string
+	OriginalFormat() string
+	LastUpdate() int64
+	Media() string
+	OriginalWidth() int64
+	OriginalHeight() int64
+	DateTaken() string
+	URLs() map[string]string
 }
 
 type API interface {
 	ExecuteMethod(string, url.Values) ([]byte, error)
+	ExecuteMethodWithContext(context.Context, string, url.Values) ([]byte, error)
 	ExecuteMethodPaginated(string, url.Values, SPRCallbackFunc) error
+	ExecuteMethodPaginatedWithContext(context.Context, string, url.Values, SPRCallbackFunc) error
 	Call(url.Values) (*http.Response, error)
+	CallWithContext(context.Context, url.Values) (*http.Response, error)
 }
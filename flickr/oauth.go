@@ -0,0 +1,258 @@
+package flickr
+
+// https://www.flickr.com/services/api/auth.oauth.html
+// https://tools.ietf.org/html/rfc5849
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const OAUTH_VERSION string = "1.0"
+
+const OAUTH_SIGNATURE_METHOD string = "HMAC-SHA1"
+
+const OAUTH_REQUEST_TOKEN_ENDPOINT string = "https://www.flickr.com/services/oauth/request_token"
+
+const OAUTH_AUTHORIZE_ENDPOINT string = "https://www.flickr.com/services/oauth/authorize"
+
+const OAUTH_ACCESS_TOKEN_ENDPOINT string = "https://www.flickr.com/services/oauth/access_token"
+
+func SignOAuthParams(http_method string, endpoint string, params url.Values, key string, secret string, token string, token_secret string) error {
+
+	nonce, err := OAuthNonce()
+
+	if err != nil {
+		return err
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	params.Del("oauth_signature")
+
+	params.Set("oauth_consumer_key", key)
+	params.Set("oauth_nonce", nonce)
+	params.Set("oauth_timestamp", ts)
+	params.Set("oauth_signature_method", OAUTH_SIGNATURE_METHOD)
+	params.Set("oauth_version", OAUTH_VERSION)
+
+	if token != "" {
+		params.Set("oauth_token", token)
+	} else {
+		params.Del("oauth_token")
+	}
+
+	sig := OAuthSignature(http_method, endpoint, params, secret, token_secret)
+	params.Set("oauth_signature", sig)
+
+	return nil
+}
+
+func OAuthSignature(http_method string, endpoint string, params url.Values, secret string, token_secret string) string {
+
+	base := OAuthSignatureBaseString(http_method, endpoint, params)
+	key := OAuthEscape(secret) + "&" + OAuthEscape(token_secret)
+
+	mac := hmac.New(sha1.New, []byte(key))
+	mac.Write([]byte(base))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func OAuthSignatureBaseString(http_method string, endpoint string, params url.Values) string {
+
+	keys := make([]string, 0)
+
+	for k := range params {
+
+		if k == "oauth_signature" {
+			continue
+		}
+
+		keys = append(keys, OAuthEscape(k))
+	}
+
+	sort.Strings(keys)
+
+	pairs := make([]string, 0)
+
+	for _, k := range keys {
+
+		raw_k, _ := url.QueryUnescape(k)
+		values := make([]string, 0)
+
+		for _, v := range params[raw_k] {
+			values = append(values, OAuthEscape(v))
+		}
+
+		sort.Strings(values)
+
+		for _, v := range values {
+			pairs = append(pairs, k+"="+v)
+		}
+	}
+
+	parts := []string{
+		strings.ToUpper(http_method),
+		OAuthEscape(endpoint),
+		OAuthEscape(strings.Join(pairs, "&")),
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// RFC 3986 says spaces are "%20" and not "+" which is what url.QueryEscape
+// produces; everything else url.QueryEscape does is what OAuth wants
+
+func OAuthEscape(str string) string {
+	return strings.Replace(url.QueryEscape(str), "+", "%20", -1)
+}
+
+func OAuthNonce() (string, error) {
+
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+type OAuthRequestToken struct {
+	Token  string
+	Secret string
+}
+
+type OAuthAccessToken struct {
+	Token    string
+	Secret   string
+	UserNSID string
+	Username string
+	Fullname string
+}
+
+func GetOAuthRequestToken(key string, secret string, callback string) (*OAuthRequestToken, error) {
+
+	if callback == "" {
+		callback = "oob"
+	}
+
+	params := url.Values{}
+	params.Set("oauth_callback", callback)
+
+	rsp, err := oauthGet(OAUTH_REQUEST_TOKEN_ENDPOINT, params, key, secret, "", "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.Get("oauth_callback_confirmed") != "true" {
+		return nil, errors.New("OAuth callback not confirmed")
+	}
+
+	rt := OAuthRequestToken{
+		Token:  rsp.Get("oauth_token"),
+		Secret: rsp.Get("oauth_token_secret"),
+	}
+
+	if rt.Token == "" || rt.Secret == "" {
+		return nil, errors.New("Unable to parse request token")
+	}
+
+	return &rt, nil
+}
+
+func OAuthAuthorizationURL(rt *OAuthRequestToken, perms string) string {
+
+	params := url.Values{}
+	params.Set("oauth_token", rt.Token)
+
+	if perms != "" {
+		params.Set("perms", perms)
+	}
+
+	return fmt.Sprintf("%s?%s", OAUTH_AUTHORIZE_ENDPOINT, params.Encode())
+}
+
+func GetOAuthAccessToken(key string, secret string, rt *OAuthRequestToken, verifier string) (*OAuthAccessToken, error) {
+
+	params := url.Values{}
+	params.Set("oauth_verifier", verifier)
+
+	rsp, err := oauthGet(OAUTH_ACCESS_TOKEN_ENDPOINT, params, key, secret, rt.Token, rt.Secret)
+
+	if err != nil {
+		return nil, err
+	}
+
+	at := OAuthAccessToken{
+		Token:    rsp.Get("oauth_token"),
+		Secret:   rsp.Get("oauth_token_secret"),
+		UserNSID: rsp.Get("user_nsid"),
+		Username: rsp.Get("username"),
+		Fullname: rsp.Get("fullname"),
+	}
+
+	if at.Token == "" || at.Secret == "" {
+		return nil, errors.New("Unable to parse access token")
+	}
+
+	return &at, nil
+}
+
+func oauthGet(endpoint string, params url.Values, key string, secret string, token string, token_secret string) (url.Values, error) {
+
+	http_method := "GET"
+
+	err := SignOAuthParams(http_method, endpoint, params, key, secret, token, token_secret)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http_method, endpoint, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.URL.RawQuery = params.Encode()
+
+	rsp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+
+	body, err := ioutil.ReadAll(rsp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// errors are returned as "oauth_problem=signature_invalid&..."
+
+	if rsp.StatusCode != 200 {
+		msg := fmt.Sprintf("%s %s", rsp.Status, strings.TrimSpace(string(body)))
+		return nil, errors.New(msg)
+	}
+
+	return url.ParseQuery(string(body))
+}
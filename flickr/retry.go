@@ -0,0 +1,202 @@
+package flickr
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+type RetryableFunc func(error) bool
+
+type RetryPolicy struct {
+	// The total number of attempts, including the first one
+	MaxAttempts int
+	// The delay before the second attempt; it doubles with each attempt after that
+	BaseDelay time.Duration
+	// The upper bound for any one delay, not counting Retry-After headers
+	MaxDelay time.Duration
+	// The fraction (0-1) of each delay that is randomized
+	Jitter float64
+	// Whether or not an error is worth retrying; if nil IsRetryable is used
+	Retryable RetryableFunc
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+
+	p := RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   1 * time.Second,
+		MaxDelay:    60 * time.Second,
+		Jitter:      0.5,
+		Retryable:   IsRetryable,
+	}
+
+	return &p
+}
+
+// Do calls fn until it succeeds, returns an error that isn't retryable,
+// p.MaxAttempts have been made or ctx is cancelled, in which case ctx.Err()
+// is returned rather than whatever fn last failed with. A nil *RetryPolicy
+// calls fn exactly once.
+
+func (p *RetryPolicy) Do(ctx context.Context, fn func() error) error {
+
+	if p == nil {
+		return fn()
+	}
+
+	attempt := 0
+
+	for {
+
+		attempt += 1
+
+		err := fn()
+
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= p.MaxAttempts || !p.isRetryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(p.Delay(attempt, err))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+			// pass
+		}
+	}
+}
+
+// Delay returns how long to wait after the attempt'th attempt failed with
+// err. A Retry-After header, if present, always wins.
+
+func (p *RetryPolicy) Delay(attempt int, err error) time.Duration {
+
+	retry_after := RetryAfter(err)
+
+	if retry_after > 0 {
+		return retry_after
+	}
+
+	d := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+
+	if p.Jitter > 0 {
+		d = d - (d * p.Jitter * rand.Float64())
+	}
+
+	return time.Duration(d)
+}
+
+func (p *RetryPolicy) isRetryable(err error) bool {
+
+	if p.Retryable == nil {
+		return IsRetryable(err)
+	}
+
+	return p.Retryable(err)
+}
+
+func IsRetryable(err error) bool {
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var api_err *APIError
+
+	if errors.As(err, &api_err) {
+		return api_err.Temporary()
+	}
+
+	var http_err *HTTPError
+
+	if errors.As(err, &http_err) {
+		return http_err.Temporary()
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+
+	var net_err net.Error
+
+	if errors.As(err, &net_err) {
+		return net_err.Timeout()
+	}
+
+	return false
+}
+
+func RetryAfter(err error) time.Duration {
+
+	var api_err *APIError
+
+	if errors.As(err, &api_err) {
+		return api_err.RetryAfter
+	}
+
+	var http_err *HTTPError
+
+	if errors.As(err, &http_err) {
+		return http_err.RetryAfter
+	}
+
+	return 0
+}
+
+// https://tools.ietf.org/html/rfc7231#section-7.1.3
+
+func ParseRetryAfter(rsp *http.Response) time.Duration {
+
+	str_retry := rsp.Header.Get("Retry-After")
+
+	if str_retry == "" {
+		return 0
+	}
+
+	secs, err := strconv.Atoi(str_retry)
+
+	if err == nil {
+
+		if secs < 0 {
+			return 0
+		}
+
+		return time.Duration(secs) * time.Second
+	}
+
+	dt, err := http.ParseTime(str_retry)
+
+	if err != nil {
+		return 0
+	}
+
+	d := time.Until(dt)
+
+	if d < 0 {
+		return 0
+	}
+
+	return d
+}
@@ -0,0 +1,208 @@
+package flickr
+
+// https://www.flickr.com/services/api/misc.urls.html
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// FlexInt decodes numbers that may or may not be encoded as strings. An
+// empty string is treated as 0.
+
+type FlexInt int64
+
+func (i *FlexInt) UnmarshalJSON(b []byte) error {
+
+	b = bytes.Trim(b, `"`)
+
+	if len(b) == 0 || string(b) == "null" {
+		*i = 0
+		return nil
+	}
+
+	v, err := strconv.ParseInt(string(b), 10, 64)
+
+	if err != nil {
+		return fmt.Errorf("Invalid integer value '%s': %w", string(b), err)
+	}
+
+	*i = FlexInt(v)
+	return nil
+}
+
+// FlexBool decodes booleans that are encoded as true/false, 0/1 or "0"/"1".
+
+type FlexBool bool
+
+func (v *FlexBool) UnmarshalJSON(b []byte) error {
+
+	b = bytes.Trim(b, `"`)
+
+	switch string(b) {
+	case "1", "true":
+		*v = true
+	case "0", "false", "", "null":
+		*v = false
+	default:
+		return fmt.Errorf("Invalid boolean value '%s'", string(b))
+	}
+
+	return nil
+}
+
+type standardPhotoResponse struct {
+	WIPStandardPhotoResponse
+	spr    *StandardPhotoResponse
+	photos []WIPStandardPhoto
+}
+
+func NewStandardPhotoResponse(body []byte) (WIPStandardPhotoResponse, error) {
+
+	var spr StandardPhotoResponse
+
+	err := json.Unmarshal(body, &spr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	photos := make([]WIPStandardPhoto, len(spr.Photos.Photos))
+
+	for i, ph := range spr.Photos.Photos {
+		photos[i] = &standardPhoto{ph: ph}
+	}
+
+	rsp := standardPhotoResponse{
+		spr:    &spr,
+		photos: photos,
+	}
+
+	return &rsp, nil
+}
+
+func (rsp *standardPhotoResponse) Page() int {
+	return int(rsp.spr.Photos.Page)
+}
+
+func (rsp *standardPhotoResponse) Pages() int {
+	return int(rsp.spr.Photos.Pages)
+}
+
+func (rsp *standardPhotoResponse) PerPage() int {
+	return int(rsp.spr.Photos.PerPage)
+}
+
+func (rsp *standardPhotoResponse) Total() int {
+	return int(rsp.spr.Photos.Total)
+}
+
+func (rsp *standardPhotoResponse) Photos() []WIPStandardPhoto {
+	return rsp.photos
+}
+
+type standardPhoto struct {
+	WIPStandardPhoto
+	ph StandardPhotoResponsePhoto
+}
+
+func (ph *standardPhoto) ID() int64 {
+	return int64(ph.ph.ID)
+}
+
+func (ph *standardPhoto) Owner() string {
+	return ph.ph.Owner
+}
+
+func (ph *standardPhoto) Secret() string {
+	return ph.ph.Secret
+}
+
+func (ph *standardPhoto) Server() int {
+	return int(ph.ph.Server)
+}
+
+func (ph *standardPhoto) Farm() int {
+	return int(ph.ph.Farm)
+}
+
+func (ph *standardPhoto) Title() string {
+	return ph.ph.Title
+}
+
+func (ph *standardPhoto) IsPublic() bool {
+	return bool(ph.ph.IsPublic)
+}
+
+func (ph *standardPhoto) IsFriend() bool {
+	return bool(ph.ph.IsFriend)
+}
+
+func (ph *standardPhoto) IsFamily() bool {
+	return bool(ph.ph.IsFamily)
+}
+
+func (ph *standardPhoto) IsPrivate() bool {
+	return !ph.IsPublic() && !ph.IsFriend() && !ph.IsFamily()
+}
+
+// https://www.flickr.com/photos/{owner}/{id}/
+
+func (ph *standardPhoto) PhotoPage() url.URL {
+
+	u := url.URL{
+		Scheme: "https",
+		Host:   "www.flickr.com",
+		Path:   fmt.Sprintf("/photos/%s/%d/", ph.Owner(), ph.ID()),
+	}
+
+	return u
+}
+
+// https://live.staticflickr.com/{server}/{id}_{secret}.jpg
+
+func (ph *standardPhoto) PhotoURL() url.URL {
+
+	u := url.URL{
+		Scheme: "https",
+		Host:   "live.staticflickr.com",
+		Path:   fmt.Sprintf("/%d/%d_%s.jpg", ph.Server(), ph.ID(), ph.Secret()),
+	}
+
+	return u
+}
+
+func (ph *standardPhoto) OriginalSecret() string {
+	return ph.ph.OriginalSecret
+}
+
+func (ph *standardPhoto) OriginalFormat() string {
+	return ph.ph.OriginalFormat
+}
+
+func (ph *standardPhoto) LastUpdate() int64 {
+	return int64(ph.ph.LastUpdate)
+}
+
+func (ph *standardPhoto) Media() string {
+	return ph.ph.Media
+}
+
+func (ph *standardPhoto) OriginalWidth() int64 {
+	return int64(ph.ph.OriginalWidth)
+}
+
+func (ph *standardPhoto) OriginalHeight() int64 {
+	return int64(ph.ph.OriginalHeight)
+}
+
+func (ph *standardPhoto) DateTaken() string {
+	return ph.ph.DateTaken
+}
+
+func (ph *standardPhoto) URLs() map[string]string {
+	return ph.ph.URLs()
+}
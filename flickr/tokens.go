@@ -0,0 +1,179 @@
+package flickr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-flickr-archive/util"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// credentials contain secrets so the token store is only ever readable
+// by the user who created it
+
+const TOKEN_STORE_FILE_PERMS os.FileMode = 0600
+
+const TOKEN_STORE_DIR_PERMS os.FileMode = 0700
+
+type Credentials struct {
+	Key         string `json:"api_key"`
+	Secret      string `json:"api_secret"`
+	Token       string `json:"oauth_token"`
+	TokenSecret string `json:"oauth_token_secret"`
+	UserNSID    string `json:"user_nsid,omitempty"`
+	Username    string `json:"username,omitempty"`
+	Perms       string `json:"perms,omitempty"`
+	Created     int64  `json:"created"`
+}
+
+type TokenStore struct {
+	path   string
+	tokens map[string]*Credentials
+	mu     *sync.RWMutex
+}
+
+func DefaultTokenStorePath() (string, error) {
+
+	home, err := os.UserHomeDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".flickr-archive", "tokens.json"), nil
+}
+
+func NewTokenStore(path string) (*TokenStore, error) {
+
+	abs_path, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[string]*Credentials)
+
+	body, err := util.ReadFile(abs_path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+
+		err = json.Unmarshal(body, &tokens)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mu := new(sync.RWMutex)
+
+	ts := TokenStore{
+		path:   abs_path,
+		tokens: tokens,
+		mu:     mu,
+	}
+
+	return &ts, nil
+}
+
+func (ts *TokenStore) Path() string {
+	return ts.path
+}
+
+func (ts *TokenStore) Accounts() []string {
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	accounts := make([]string, 0)
+
+	for account := range ts.tokens {
+		accounts = append(accounts, account)
+	}
+
+	sort.Strings(accounts)
+	return accounts
+}
+
+func (ts *TokenStore) Get(account string) (*Credentials, error) {
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	creds, ok := ts.tokens[account]
+
+	if !ok {
+		msg := fmt.Sprintf("No credentials for account '%s'", account)
+		return nil, errors.New(msg)
+	}
+
+	return creds, nil
+}
+
+func (ts *TokenStore) Set(account string, creds *Credentials) error {
+
+	if account == "" {
+		return errors.New("Missing account name")
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.tokens[account] = creds
+	return ts.save()
+}
+
+func (ts *TokenStore) Delete(account string) error {
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	delete(ts.tokens, account)
+	return ts.save()
+}
+
+func (ts *TokenStore) save() error {
+
+	root := filepath.Dir(ts.path)
+
+	err := os.MkdirAll(root, TOKEN_STORE_DIR_PERMS)
+
+	if err != nil {
+		return err
+	}
+
+	body, err := json.MarshalIndent(ts.tokens, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return util.WriteFileWithPerms(ts.path, body, TOKEN_STORE_FILE_PERMS)
+}
+
+func NewFlickrAuthAPIWithCredentials(creds *Credentials) (API, error) {
+	return NewFlickrAuthAPIWithAccessToken(creds.Key, creds.Secret, creds.Token, creds.TokenSecret)
+}
+
+func NewFlickrAuthAPIForAccount(path string, account string) (API, error) {
+
+	ts, err := NewTokenStore(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := ts.Get(account)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFlickrAuthAPIWithCredentials(creds)
+}
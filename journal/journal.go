@@ -0,0 +1,153 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-flickr-archive/flickr"
+	"github.com/aaronland/go-flickr-archive/util"
+	"github.com/aaronland/go-storage"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const JOURNAL_PREFIX string = "_journal"
+
+type Entry struct {
+	PhotoID   int64  `json:"photo_id"`
+	Method    string `json:"method"`
+	Error     string `json:"error"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type Journal interface {
+	Record(*Entry) error
+	Remove(int64) error
+	Entries() ([]*Entry, error)
+}
+
+func NewEntry(photo_id int64, err error) *Entry {
+
+	// the method is whatever API call (or download) failed which is
+	// useful to know when deciding whether a retry is worth it
+
+	method := "archive"
+
+	var api_err *flickr.APIError
+	var http_err *flickr.HTTPError
+
+	if errors.As(err, &api_err) {
+		method = api_err.Method
+	} else if errors.As(err, &http_err) {
+		method = "download"
+	}
+
+	e := Entry{
+		PhotoID:   photo_id,
+		Method:    method,
+		Error:     err.Error(),
+		Timestamp: time.Now().Unix(),
+	}
+
+	return &e
+}
+
+// StoreJournal writes one file per failed photo, rather than appending to a
+// single file, because not every storage.Store can append.
+
+type StoreJournal struct {
+	Journal
+	store storage.Store
+}
+
+func NewStoreJournal(store storage.Store) (Journal, error) {
+
+	j := StoreJournal{
+		store: store,
+	}
+
+	return &j, nil
+}
+
+func (j *StoreJournal) Record(e *Entry) error {
+
+	enc, err := json.Marshal(e)
+
+	if err != nil {
+		return err
+	}
+
+	// an entry for a photo that has failed before is replaced
+
+	key := j.key(e.PhotoID)
+
+	fh := ioutil.NopCloser(bytes.NewReader(enc))
+	return util.Replace(j.store, key, fh)
+}
+
+func (j *StoreJournal) Remove(photo_id int64) error {
+	return j.store.Delete(j.key(photo_id))
+}
+
+func (j *StoreJournal) Entries() ([]*Entry, error) {
+
+	keys := make([]string, 0)
+
+	cb := func(path string, args ...interface{}) error {
+
+		key := util.StoreKey(j.store, path)
+
+		if strings.HasPrefix(key, JOURNAL_PREFIX+"/") && filepath.Ext(key) == ".json" {
+			keys = append(keys, key)
+		}
+
+		return nil
+	}
+
+	err := j.store.Walk(cb)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(keys)
+
+	entries := make([]*Entry, 0)
+
+	for _, key := range keys {
+
+		fh, err := j.store.Get(key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(fh)
+		fh.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		var e Entry
+
+		err = json.Unmarshal(body, &e)
+
+		if err != nil {
+			msg := fmt.Sprintf("Failed to parse journal entry %s: %s", key, err)
+			return nil, errors.New(msg)
+		}
+
+		entries = append(entries, &e)
+	}
+
+	return entries, nil
+}
+
+func (j *StoreJournal) key(photo_id int64) string {
+	return fmt.Sprintf("%s/%d.json", JOURNAL_PREFIX, photo_id)
+}
@@ -0,0 +1,208 @@
+package photo
+
+// https://www.flickr.com/services/api/flickr.photos.getInfo.html
+// https://www.flickr.com/services/api/misc.dates.html
+
+import (
+	"errors"
+	"github.com/tidwall/gjson"
+	"time"
+)
+
+// the layout for "taken" dates, which have no timezone
+
+const TAKEN_LAYOUT string = "2006-01-02 15:04:05"
+
+const TAKEN_GRANULARITY_SECOND int = 0
+
+const TAKEN_GRANULARITY_MONTH int = 4
+
+const TAKEN_GRANULARITY_YEAR int = 6
+
+const TAKEN_GRANULARITY_CIRCA int = 8
+
+type Owner struct {
+	NSID      string `json:"nsid"`
+	Username  string `json:"username,omitempty"`
+	Realname  string `json:"realname,omitempty"`
+	PathAlias string `json:"path_alias,omitempty"`
+	Location  string `json:"location,omitempty"`
+}
+
+type Dates struct {
+	Posted           int64  `json:"posted"`
+	Taken            string `json:"taken"`
+	TakenGranularity int    `json:"takengranularity"`
+	TakenUnknown     bool   `json:"takenunknown"`
+	LastUpdate       int64  `json:"lastupdate"`
+}
+
+// PostedTime returns the date the photo was uploaded.
+
+func (d *Dates) PostedTime() time.Time {
+	return time.Unix(d.Posted, 0)
+}
+
+// TakenTime returns the date the photo was taken in loc since Flickr stores
+// dates taken as wall-clock times without any timezone information.
+
+func (d *Dates) TakenTime(loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation(TAKEN_LAYOUT, d.Taken, loc)
+}
+
+type Visibility struct {
+	IsPublic bool `json:"ispublic"`
+	IsFriend bool `json:"isfriend"`
+	IsFamily bool `json:"isfamily"`
+}
+
+func (v *Visibility) IsPrivate() bool {
+	return !v.IsPublic && !v.IsFriend && !v.IsFamily
+}
+
+type Tag struct {
+	ID         string `json:"id"`
+	Author     string `json:"author"`
+	Raw        string `json:"raw"`
+	Content    string `json:"_content"`
+	MachineTag bool   `json:"machine_tag"`
+}
+
+type Location struct {
+	Latitude      float64 `json:"latitude"`
+	Longitude     float64 `json:"longitude"`
+	Accuracy      int     `json:"accuracy"`
+	Context       int     `json:"context"`
+	Neighbourhood string  `json:"neighbourhood,omitempty"`
+	Locality      string  `json:"locality,omitempty"`
+	County        string  `json:"county,omitempty"`
+	Region        string  `json:"region,omitempty"`
+	Country       string  `json:"country,omitempty"`
+	PlaceID       string  `json:"place_id,omitempty"`
+	WOEID         string  `json:"woeid,omitempty"`
+}
+
+// NewFlickrPhotoFromInfo parses the response from flickr.photos.getInfo.
+// Flickr is inconsistent about whether numbers are encoded as numbers or
+// strings so everything is read leniently.
+
+func NewFlickrPhotoFromInfo(info []byte) (*FlickrPhoto, error) {
+
+	rsp := gjson.GetBytes(info, "photo")
+
+	if !rsp.Exists() {
+		return nil, errors.New("Unable to find photo")
+	}
+
+	id := rsp.Get("id")
+
+	if !id.Exists() || id.Int() == 0 {
+		return nil, errors.New("Unable to determine photo ID")
+	}
+
+	secret := rsp.Get("secret")
+
+	if !secret.Exists() {
+		return nil, errors.New("Unable to determine photo secret")
+	}
+
+	ph := FlickrPhoto{
+		ID:             id.Int(),
+		Secret:         secret.String(),
+		OriginalSecret: rsp.Get("originalsecret").String(),
+		OriginalFormat: rsp.Get("originalformat").String(),
+		Media:          rsp.Get("media").String(),
+		Server:         rsp.Get("server").String(),
+		Farm:           int(rsp.Get("farm").Int()),
+		Title:          rsp.Get("title._content").String(),
+		Description:    rsp.Get("description._content").String(),
+		License:        rsp.Get("license").String(),
+	}
+
+	owner := rsp.Get("owner")
+
+	if owner.Exists() {
+
+		ph.Owner = &Owner{
+			NSID:      owner.Get("nsid").String(),
+			Username:  owner.Get("username").String(),
+			Realname:  owner.Get("realname").String(),
+			PathAlias: owner.Get("path_alias").String(),
+			Location:  owner.Get("location").String(),
+		}
+	}
+
+	dates := rsp.Get("dates")
+
+	if dates.Exists() {
+
+		ph.Dates = &Dates{
+			Posted:           dates.Get("posted").Int(),
+			Taken:            dates.Get("taken").String(),
+			TakenGranularity: int(dates.Get("takengranularity").Int()),
+			TakenUnknown:     dates.Get("takenunknown").Bool(),
+			LastUpdate:       dates.Get("lastupdate").Int(),
+		}
+
+		ph.LastUpdate = ph.Dates.LastUpdate
+	}
+
+	visibility := rsp.Get("visibility")
+
+	if visibility.Exists() {
+
+		ph.Visibility = &Visibility{
+			IsPublic: visibility.Get("ispublic").Bool(),
+			IsFriend: visibility.Get("isfriend").Bool(),
+			IsFamily: visibility.Get("isfamily").Bool(),
+		}
+	}
+
+	tags := make([]*Tag, 0)
+
+	for _, t := range rsp.Get("tags.tag").Array() {
+
+		tag := Tag{
+			ID:         t.Get("id").String(),
+			Author:     t.Get("author").String(),
+			Raw:        t.Get("raw").String(),
+			Content:    t.Get("_content").String(),
+			MachineTag: t.Get("machine_tag").Bool(),
+		}
+
+		tags = append(tags, &tag)
+	}
+
+	if len(tags) > 0 {
+		ph.Tags = tags
+	}
+
+	location := rsp.Get("location")
+
+	if location.Exists() {
+
+		ph.Location = &Location{
+			Latitude:      location.Get("latitude").Float(),
+			Longitude:     location.Get("longitude").Float(),
+			Accuracy:      int(location.Get("accuracy").Int()),
+			Context:       int(location.Get("context").Int()),
+			Neighbourhood: location.Get("neighbourhood._content").String(),
+			Locality:      location.Get("locality._content").String(),
+			County:        location.Get("county._content").String(),
+			Region:        location.Get("region._content").String(),
+			Country:       location.Get("country._content").String(),
+			PlaceID:       location.Get("place_id").String(),
+			WOEID:         location.Get("woeid").String(),
+		}
+	}
+
+	for _, u := range rsp.Get("urls.url").Array() {
+
+		if u.Get("type").String() == "photopage" {
+			ph.PhotoPage = u.Get("_content").String()
+			break
+		}
+	}
+
+	return &ph, nil
+}
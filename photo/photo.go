@@ -1,6 +1,7 @@
 package photo
 
 import (
+	"github.com/aaronland/go-flickr-archive/flickr"
 	"strconv"
 )
 
@@ -13,8 +14,31 @@ type Archivist interface {
 }
 
 type FlickrPhoto struct {
-	Photo `json:",omitempty"`
-	ID    int64 `json:"id"`
+	Photo          `json:",omitempty"`
+	ID             int64  `json:"id"`
+	Secret         string `json:"secret,omitempty"`
+	OriginalSecret string `json:"originalsecret,omitempty"`
+	OriginalFormat string `json:"originalformat,omitempty"`
+	LastUpdate     int64  `json:"lastupdate,omitempty"`
+	Media          string `json:"media,omitempty"`
+	OriginalWidth  int64  `json:"o_width,omitempty"`
+	OriginalHeight int64  `json:"o_height,omitempty"`
+	// keyed by size label; an empty string means the URL was asked for
+	// and that size doesn't exist (or isn't visible to us)
+	URLs map[string]string `json:"urls,omitempty"`
+	// the following are only populated by NewFlickrPhotoFromInfo, apart
+	// from Owner.NSID and Dates.Taken which searches can include
+	Server      string      `json:"server,omitempty"`
+	Farm        int         `json:"farm,omitempty"`
+	Owner       *Owner      `json:"owner,omitempty"`
+	Title       string      `json:"title,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Dates       *Dates      `json:"dates,omitempty"`
+	Visibility  *Visibility `json:"visibility,omitempty"`
+	License     string      `json:"license,omitempty"`
+	Tags        []*Tag      `json:"tags,omitempty"`
+	Location    *Location   `json:"location,omitempty"`
+	PhotoPage   string      `json:"photopage,omitempty"`
 }
 
 func NewFlickrPhotoFromString(str_id string) (Photo, error) {
@@ -37,6 +61,77 @@ func NewFlickrPhoto(id int64) (Photo, error) {
 	return &ph, nil
 }
 
+// NewFlickrPhotoFromSPR returns a photo with whatever extras were included in
+// a standard photo response. extras are the extras that were requested.
+
+func NewFlickrPhotoFromSPR(spr_ph flickr.WIPStandardPhoto, extras []string) (Photo, error) {
+
+	ph := FlickrPhoto{
+		ID:             spr_ph.ID(),
+		Secret:         spr_ph.Secret(),
+		OriginalSecret: spr_ph.OriginalSecret(),
+		OriginalFormat: spr_ph.OriginalFormat(),
+		LastUpdate:     spr_ph.LastUpdate(),
+		Media:          spr_ph.Media(),
+		OriginalWidth:  spr_ph.OriginalWidth(),
+		OriginalHeight: spr_ph.OriginalHeight(),
+	}
+
+	// enough for layouts that group photos by owner or date taken
+
+	if spr_ph.Owner() != "" {
+		ph.Owner = &Owner{
+			NSID: spr_ph.Owner(),
+		}
+	}
+
+	if spr_ph.DateTaken() != "" {
+		ph.Dates = &Dates{
+			Taken:      spr_ph.DateTaken(),
+			LastUpdate: spr_ph.LastUpdate(),
+		}
+	}
+
+	spr_urls := spr_ph.URLs()
+	urls := make(map[string]string)
+
+	for label, suffix := range flickr.SIZE_SUFFIXES {
+
+		for _, e := range extras {
+
+			if e == "url_"+suffix {
+				urls[label] = spr_urls[label]
+				break
+			}
+		}
+	}
+
+	if len(urls) > 0 {
+		ph.URLs = urls
+	}
+
+	return &ph, nil
+}
+
 func (ph *FlickrPhoto) Id() int64 {
 	return ph.ID
 }
+
+// HasArchiveExtras reports whether ph was created with enough of
+// flickr.ARCHIVE_EXTRAS to be archived without calling flickr.photos.getInfo
+
+func (ph *FlickrPhoto) HasArchiveExtras() bool {
+	return ph.Secret != "" && ph.LastUpdate != 0 && ph.Media != ""
+}
+
+// ArchiveSecret is the secret that archived files are named with, which is
+// the original secret if we know it.
+
+func (ph *FlickrPhoto) ArchiveSecret() string {
+
+	if ph.OriginalSecret != "" {
+		return ph.OriginalSecret
+	}
+
+	return ph.Secret
+}
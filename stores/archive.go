@@ -0,0 +1,313 @@
+package stores
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-storage"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// archiveWriter is the part of the tar and zip stores that differs.
+
+type archiveWriter interface {
+	// WriteEntry writes a file to the archive. size is the number of
+	// bytes that r will return.
+	WriteEntry(key string, r io.Reader, size int64) error
+	Close() error
+}
+
+// archiveStore is a storage.Store that writes everything to a single file
+// (a tar or zip archive) as it goes. It keeps an in-memory index of what has
+// been written so that Exists and Walk work, but once something has been
+// written it can't be read back or removed. Keys that start with an
+// underscore, like the journal and checkpoints, are bookkeeping that gets
+// rewritten and deleted as we go so they are kept in memory instead and
+// written when the store is closed.
+
+type archiveStore struct {
+	storage.Store
+	path     string
+	writer   archiveWriter
+	index    map[string]int64
+	buffered map[string][]byte
+	closed   bool
+	mu       *sync.Mutex
+}
+
+type archiveFile struct {
+	io.WriteCloser
+	store *archiveStore
+	key   string
+	buf   *bytes.Buffer
+}
+
+func (f *archiveFile) Write(b []byte) (int, error) {
+	return f.buf.Write(b)
+}
+
+func (f *archiveFile) Close() error {
+	return f.store.Put(f.key, ioutil.NopCloser(f.buf))
+}
+
+// archivePath returns the local path for an archive URI, refusing to clobber
+// an existing file unless the URI says overwrite=true.
+
+func archivePath(u *url.URL) (string, error) {
+
+	path := u.Host + u.Path
+
+	if path == "" {
+		msg := fmt.Sprintf("Missing path for %s storage", u.Scheme)
+		return "", errors.New(msg)
+	}
+
+	abs_path, err := filepath.Abs(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	_, err = os.Stat(abs_path)
+
+	if err == nil && u.Query().Get("overwrite") != "true" {
+		msg := fmt.Sprintf("%s already exists, add ?overwrite=true to replace it", abs_path)
+		return "", errors.New(msg)
+	}
+
+	err = os.MkdirAll(filepath.Dir(abs_path), 0755)
+
+	if err != nil {
+		return "", err
+	}
+
+	return abs_path, nil
+}
+
+func newArchiveStore(path string, w archiveWriter) *archiveStore {
+
+	s := archiveStore{
+		path:     path,
+		writer:   w,
+		index:    make(map[string]int64),
+		buffered: make(map[string][]byte),
+		mu:       new(sync.Mutex),
+	}
+
+	return &s
+}
+
+func (s *archiveStore) URI(k string) string {
+	return s.path + "/" + k
+}
+
+func (s *archiveStore) Get(k string) (io.ReadCloser, error) {
+
+	k = archiveKey(k)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, ok := s.buffered[k]
+
+	if ok {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	_, ok = s.index[k]
+
+	if ok {
+		msg := fmt.Sprintf("Can not read %s from %s while it is being written", k, s.path)
+		return nil, errors.New(msg)
+	}
+
+	msg := fmt.Sprintf("%s not found in %s", k, s.path)
+	return nil, errors.New(msg)
+}
+
+func (s *archiveStore) Put(k string, in io.ReadCloser) error {
+
+	k = archiveKey(k)
+
+	if isBookkeeping(k) {
+
+		body, err := ioutil.ReadAll(in)
+
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.closed {
+			return s.closedError()
+		}
+
+		s.buffered[k] = body
+		return nil
+	}
+
+	// spool in before taking the lock, even for zip files which don't need
+	// to know the size up front, so that one slow download doesn't hold up
+	// everything else
+
+	r, size, cleanup, err := spool(in)
+
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return s.closedError()
+	}
+
+	err = s.writer.WriteEntry(k, r, size)
+
+	if err != nil {
+		return err
+	}
+
+	s.index[k] = size
+	return nil
+}
+
+func (s *archiveStore) Open(k string) (io.WriteCloser, error) {
+
+	f := archiveFile{
+		store: s,
+		key:   k,
+		buf:   new(bytes.Buffer),
+	}
+
+	return &f, nil
+}
+
+// Delete removes k from the index. Anything that has already been written to
+// the archive stays there; if k is written again the new copy is the one
+// that wins when the archive is unpacked.
+
+func (s *archiveStore) Delete(k string) error {
+
+	k = archiveKey(k)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.buffered, k)
+	delete(s.index, k)
+
+	return nil
+}
+
+func (s *archiveStore) Exists(k string) (bool, error) {
+
+	k = archiveKey(k)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.buffered[k]
+
+	if ok {
+		return true, nil
+	}
+
+	_, ok = s.index[k]
+	return ok, nil
+}
+
+func (s *archiveStore) Walk(cb storage.WalkFunc) error {
+
+	s.mu.Lock()
+
+	keys := make([]string, 0)
+
+	for k := range s.index {
+		keys = append(keys, k)
+	}
+
+	for k := range s.buffered {
+		keys = append(keys, k)
+	}
+
+	s.mu.Unlock()
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+
+		err := cb(s.URI(k))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close writes any bookkeeping files and finishes the archive. It is safe to
+// call more than once.
+
+func (s *archiveStore) Close() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+
+	keys := make([]string, 0)
+
+	for k := range s.buffered {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+
+		body := s.buffered[k]
+
+		err := s.writer.WriteEntry(k, bytes.NewReader(body), int64(len(body)))
+
+		if err != nil {
+			s.writer.Close()
+			return err
+		}
+	}
+
+	return s.writer.Close()
+}
+
+func (s *archiveStore) closedError() error {
+	msg := fmt.Sprintf("%s has already been closed", s.path)
+	return errors.New(msg)
+}
+
+// archiveKey returns the name that k is written to the archive as, which is
+// always relative and always uses forward slashes.
+
+func archiveKey(k string) string {
+	return strings.TrimLeft(filepath.ToSlash(k), "/")
+}
+
+func isBookkeeping(k string) bool {
+	return strings.HasPrefix(k, "_")
+}
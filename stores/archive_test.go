@@ -0,0 +1,268 @@
+package stores
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// archiveEntry is a file read back from a tar or zip archive, in the order
+// it was written.
+
+type archiveEntry struct {
+	name string
+	body []byte
+}
+
+func readTarGz(t *testing.T, path string) []archiveEntry {
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer fh.Close()
+
+	gz, err := gzip.NewReader(fh)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(gz)
+
+	entries := make([]archiveEntry, 0)
+
+	for {
+
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := ioutil.ReadAll(tr)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entries = append(entries, archiveEntry{hdr.Name, body})
+	}
+
+	return entries
+}
+
+func readZip(t *testing.T, path string) []archiveEntry {
+
+	zr, err := zip.OpenReader(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer zr.Close()
+
+	entries := make([]archiveEntry, 0)
+
+	for _, f := range zr.File {
+
+		fh, err := f.Open()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := ioutil.ReadAll(fh)
+
+		fh.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entries = append(entries, archiveEntry{f.Name, body})
+	}
+
+	return entries
+}
+
+func TestArchiveStores(t *testing.T) {
+
+	tests := []struct {
+		scheme string
+		name   string
+		read   func(*testing.T, string) []archiveEntry
+	}{
+		{"tar", "archive.tar.gz", readTarGz},
+		{"zip", "archive.zip", readZip},
+	}
+
+	// bigger than SPOOL_MAX_MEMORY so that it goes through a temporary
+	// file on its way in to the archive
+
+	big := bytes.Repeat([]byte("0123456789abcdef"), int(SPOOL_MAX_MEMORY/16)+1024)
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			path := filepath.Join(t.TempDir(), test.name)
+
+			store, err := NewStore(fmt.Sprintf("%s://%s", test.scheme, path))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			put := func(k string, body []byte) {
+
+				err := store.Put(k, ioutil.NopCloser(bytes.NewReader(body)))
+
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// bookkeeping first, to show that it isn't written until
+			// the store is closed
+
+			put("_journal/123.json", []byte(`{"id":123,"error":"first"}`))
+			put("_journal/123.json", []byte(`{"id":123}`))
+			put("_journal/456.json", []byte(`{"id":456}`))
+
+			put("/123/123_abc_o.jpg", big)
+			put("123/123_abc_i.json", []byte(`{"photo":{}}`))
+
+			fh, err := store.Open("456/456_def_o.jpg")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = fh.Write([]byte("jpeg"))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = fh.Close()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.Delete("/_journal/456.json")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, k := range []string{"123/123_abc_o.jpg", "/123/123_abc_o.jpg", "/456/456_def_o.jpg", "/_journal/123.json"} {
+
+				exists, err := store.Exists(k)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !exists {
+					t.Errorf("Expected %s to exist", k)
+				}
+			}
+
+			exists, err := store.Exists("_journal/456.json")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if exists {
+				t.Errorf("Expected _journal/456.json to have been deleted")
+			}
+
+			// bookkeeping can be read back until it's written, photos
+			// can't be read back at all
+
+			_, err = store.Get("/_journal/123.json")
+
+			if err != nil {
+				t.Errorf("Failed to read bookkeeping before closing, %s", err)
+			}
+
+			_, err = store.Get("123/123_abc_o.jpg")
+
+			if err == nil {
+				t.Errorf("Expected reading a photo back to fail")
+			}
+
+			err = Close(store)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = Close(store)
+
+			if err != nil {
+				t.Errorf("Expected closing twice to be a no-op but got %s", err)
+			}
+
+			err = store.Put("789/789_ghi_o.jpg", ioutil.NopCloser(bytes.NewReader([]byte("jpeg"))))
+
+			if err == nil {
+				t.Errorf("Expected Put after Close to fail")
+			}
+
+			err = store.Put("_journal/789.json", ioutil.NopCloser(bytes.NewReader([]byte(`{"id":789}`))))
+
+			if err == nil {
+				t.Errorf("Expected Put for bookkeeping after Close to fail")
+			}
+
+			expected := []archiveEntry{
+				{"123/123_abc_o.jpg", big},
+				{"123/123_abc_i.json", []byte(`{"photo":{}}`)},
+				{"456/456_def_o.jpg", []byte("jpeg")},
+				{"_journal/123.json", []byte(`{"id":123}`)},
+			}
+
+			entries := test.read(t, path)
+
+			if len(entries) != len(expected) {
+
+				names := make([]string, len(entries))
+
+				for i, e := range entries {
+					names[i] = e.name
+				}
+
+				t.Fatalf("Expected %d entries but got %v", len(expected), names)
+			}
+
+			for i, e := range expected {
+
+				if entries[i].name != e.name {
+					t.Errorf("Expected entry %d to be %s but got %s", i, e.name, entries[i].name)
+					continue
+				}
+
+				if !bytes.Equal(entries[i].body, e.body) {
+					t.Errorf("Wrong contents for %s (%d bytes)", e.name, len(entries[i].body))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package stores
+
+import (
+	"github.com/aaronland/go-storage"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const FS_FILE_PERMS os.FileMode = 0644
+
+const FS_DIR_PERMS os.FileMode = 0755
+
+// files are written to a temporary file whose name starts with this, in the
+// same directory, before being renamed in to place
+
+const FS_TEMP_PREFIX string = ".flickr-archive-tmp-"
+
+// AtomicFSStore is a storage.FSStore that writes files to a temporary file
+// and renames them in to place once they're complete, so a failed download or
+// a process that gets killed never leaves a partial file behind. It also
+// means files are replaced rather than written over. Temporary files left by
+// a process that got killed are skipped by Walk.
+
+type AtomicFSStore struct {
+	storage.Store
+	root string
+}
+
+func NewAtomicFSStore(root string) (storage.Store, error) {
+
+	abs_root, err := filepath.Abs(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fs, err := storage.NewFSStore("root=" + abs_root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := AtomicFSStore{
+		Store: fs,
+		root:  abs_root,
+	}
+
+	return &s, nil
+}
+
+func (s *AtomicFSStore) Put(k string, in io.ReadCloser) error {
+
+	defer in.Close()
+
+	fh, err := s.open(k)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fh, in)
+
+	if err != nil {
+		fh.Abort()
+		return err
+	}
+
+	return fh.Close()
+}
+
+// Open returns a file that appears at k when it is closed.
+
+func (s *AtomicFSStore) Open(k string) (io.WriteCloser, error) {
+	return s.open(k)
+}
+
+func (s *AtomicFSStore) Walk(cb storage.WalkFunc) error {
+
+	walk_cb := func(path string, args ...interface{}) error {
+
+		if isTempFile(path) {
+			return nil
+		}
+
+		return cb(path, args...)
+	}
+
+	return s.Store.Walk(walk_cb)
+}
+
+func (s *AtomicFSStore) open(k string) (*atomicFile, error) {
+
+	path := filepath.Join(s.root, k)
+
+	err := os.MkdirAll(filepath.Dir(path), FS_DIR_PERMS)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newAtomicFile(path, FS_FILE_PERMS)
+}
+
+// isTempFile reports whether path is one of the temporary files that
+// AtomicFSStore writes to.
+
+func isTempFile(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), FS_TEMP_PREFIX)
+}
+
+type atomicFile struct {
+	*os.File
+	path string
+}
+
+func newAtomicFile(path string, perms os.FileMode) (*atomicFile, error) {
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), FS_TEMP_PREFIX+filepath.Base(path)+"-")
+
+	if err != nil {
+		return nil, err
+	}
+
+	f := atomicFile{
+		File: tmp,
+		path: path,
+	}
+
+	err = tmp.Chmod(perms)
+
+	if err != nil {
+		f.Abort()
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// Close renames the temporary file in to place, or removes it if that isn't
+// possible.
+
+func (f *atomicFile) Close() error {
+
+	err := f.File.Close()
+
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = os.Rename(f.Name(), f.path)
+
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// Abort removes the temporary file without putting anything in place.
+
+func (f *atomicFile) Abort() {
+	f.File.Close()
+	os.Remove(f.Name())
+}
@@ -0,0 +1,85 @@
+package stores
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAtomicFSStoreWalk(t *testing.T) {
+
+	root := t.TempDir()
+
+	store, err := NewAtomicFSStore(root)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Put("123/123_abc_o.jpg", ioutil.NopCloser(bytes.NewReader([]byte("jpeg"))))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a file that is still being written, or was when the process writing
+	// it got killed
+
+	fh, err := store.Open("123/123_abc_i.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fh.Write([]byte("{"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	on_disk, err := filepath.Glob(filepath.Join(root, "123", "*"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(on_disk) != 2 {
+		t.Fatalf("Expected a photo and a temporary file but found %v", on_disk)
+	}
+
+	keys := make([]string, 0)
+
+	cb := func(path string, args ...interface{}) error {
+		keys = append(keys, strings.TrimPrefix(path, root+string(os.PathSeparator)))
+		return nil
+	}
+
+	err = store.Walk(cb)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(keys, ",") != "123/123_abc_o.jpg" {
+		t.Errorf("Expected only 123/123_abc_o.jpg but got %v", keys)
+	}
+
+	err = fh.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := ioutil.ReadFile(filepath.Join(root, "123", "123_abc_i.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "{" {
+		t.Errorf("Expected '{' but got '%s'", body)
+	}
+}
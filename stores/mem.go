@@ -0,0 +1,149 @@
+package stores
+
+import (
+	"bytes"
+	"errors"
+	"github.com/aaronland/go-storage"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+const MEM_URI_PREFIX string = "mem://"
+
+// MemStore keeps everything in memory. It is mostly useful for trying things
+// out and as a stand-in for other stores.
+
+type MemStore struct {
+	storage.Store
+	files map[string][]byte
+	mu    *sync.RWMutex
+}
+
+type memFile struct {
+	io.WriteCloser
+	store *MemStore
+	key   string
+	buf   *bytes.Buffer
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	return f.buf.Write(b)
+}
+
+func (f *memFile) Close() error {
+	f.store.set(f.key, f.buf.Bytes())
+	return nil
+}
+
+func NewMemStoreWithURI(u *url.URL) (storage.Store, error) {
+	return NewMemStore()
+}
+
+func NewMemStore() (storage.Store, error) {
+
+	s := MemStore{
+		files: make(map[string][]byte),
+		mu:    new(sync.RWMutex),
+	}
+
+	return &s, nil
+}
+
+func (s *MemStore) URI(k string) string {
+	return MEM_URI_PREFIX + k
+}
+
+func (s *MemStore) Get(k string) (io.ReadCloser, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	body, ok := s.files[k]
+
+	if !ok {
+		return nil, errors.New("Key not found")
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(body)), nil
+}
+
+func (s *MemStore) Put(k string, in io.ReadCloser) error {
+
+	body, err := ioutil.ReadAll(in)
+
+	if err != nil {
+		return err
+	}
+
+	s.set(k, body)
+	return nil
+}
+
+func (s *MemStore) Open(k string) (io.WriteCloser, error) {
+
+	f := memFile{
+		store: s,
+		key:   k,
+		buf:   new(bytes.Buffer),
+	}
+
+	return &f, nil
+}
+
+func (s *MemStore) Delete(k string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.files, k)
+	return nil
+}
+
+func (s *MemStore) Exists(k string) (bool, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.files[k]
+	return ok, nil
+}
+
+// Walk calls cb with the URI of each file, sorted by key, like
+// storage.FSStore which passes absolute paths.
+
+func (s *MemStore) Walk(cb storage.WalkFunc) error {
+
+	s.mu.RLock()
+
+	keys := make([]string, 0)
+
+	for k := range s.files {
+		keys = append(keys, k)
+	}
+
+	s.mu.RUnlock()
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+
+		err := cb(s.URI(k))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *MemStore) set(k string, body []byte) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[k] = body
+}
@@ -0,0 +1,633 @@
+package stores
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjectsV2.html
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-flickr-archive/util"
+	"github.com/aaronland/go-storage"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const S3_SIGNING_ALGORITHM string = "AWS4-HMAC-SHA256"
+
+// the SHA-256 hash of an empty request body
+
+const S3_EMPTY_PAYLOAD_HASH string = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// how long any one request, including reading or writing its body, is
+// allowed to take unless the URI says otherwise (?timeout=30m)
+
+const S3_DEFAULT_TIMEOUT time.Duration = 10 * time.Minute
+
+// how long to wait for S3 to start answering once a request has been sent
+
+const S3_RESPONSE_HEADER_TIMEOUT time.Duration = 60 * time.Second
+
+// S3Store talks to S3, or anything that speaks the same API, using nothing
+// but the standard library. URIs look like this:
+//
+// s3://bucket/optional/prefix?region=us-east-1
+// s3://bucket?endpoint=http://localhost:9000
+// s3://bucket?timeout=30m
+//
+// Credentials are read from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// (optionally) AWS_SESSION_TOKEN environment variables rather than the URI so
+// they don't end up in shell histories. If an endpoint is given then requests
+// use path-style addressing, which is what most S3 stand-ins expect.
+
+type S3Store struct {
+	storage.Store
+	bucket        string
+	prefix        string
+	region        string
+	endpoint      *url.URL
+	path_style    bool
+	access_key    string
+	secret_key    string
+	session_token string
+	timeout       time.Duration
+	client        *http.Client
+}
+
+// s3File pipes whatever is written to it in to Put, which is running in the
+// background, rather than keeping it all in memory.
+
+type s3File struct {
+	io.WriteCloser
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (f *s3File) Write(b []byte) (int, error) {
+	return f.pw.Write(b)
+}
+
+func (f *s3File) Close() error {
+
+	f.pw.Close()
+	return <-f.done
+}
+
+// s3Body cancels the context of the request it is the body of once it has
+// been read (or not).
+
+type s3Body struct {
+	io.ReadCloser
+	body   io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *s3Body) Read(p []byte) (int, error) {
+	return b.body.Read(p)
+}
+
+func (b *s3Body) Close() error {
+
+	defer b.cancel()
+	return b.body.Close()
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func NewS3StoreWithURI(u *url.URL) (storage.Store, error) {
+
+	bucket := u.Host
+
+	if bucket == "" {
+		return nil, errors.New("Missing S3 bucket")
+	}
+
+	q := u.Query()
+
+	region := q.Get("region")
+
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	str_endpoint := q.Get("endpoint")
+
+	if str_endpoint == "" {
+		str_endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region)
+	}
+
+	endpoint, err := url.Parse(str_endpoint)
+
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := S3_DEFAULT_TIMEOUT
+
+	str_timeout := q.Get("timeout")
+
+	if str_timeout != "" {
+
+		timeout, err = time.ParseDuration(str_timeout)
+
+		if err != nil {
+			msg := fmt.Sprintf("Invalid S3 timeout '%s': %s", str_timeout, err)
+			return nil, errors.New(msg)
+		}
+	}
+
+	// the timeout is enforced on each request's context (see do) since
+	// http.Client.Timeout would also cut off large downloads that are
+	// being read slowly by the caller
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: S3_RESPONSE_HEADER_TIMEOUT,
+		ExpectContinueTimeout: 1 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          100,
+	}
+
+	access_key := os.Getenv("AWS_ACCESS_KEY_ID")
+	secret_key := os.Getenv("AWS_SECRET_ACCESS_KEY")
+
+	if access_key == "" || secret_key == "" {
+		return nil, errors.New("Missing AWS_ACCESS_KEY_ID or AWS_SECRET_ACCESS_KEY environment variables")
+	}
+
+	s := S3Store{
+		bucket:        bucket,
+		prefix:        strings.Trim(u.Path, "/"),
+		region:        region,
+		endpoint:      endpoint,
+		path_style:    q.Get("endpoint") != "",
+		access_key:    access_key,
+		secret_key:    secret_key,
+		session_token: os.Getenv("AWS_SESSION_TOKEN"),
+		timeout:       timeout,
+		client:        &http.Client{Transport: transport},
+	}
+
+	return &s, nil
+}
+
+func (s *S3Store) URI(k string) string {
+
+	if s.prefix == "" {
+		return fmt.Sprintf("s3://%s/%s", s.bucket, k)
+	}
+
+	return fmt.Sprintf("s3://%s/%s/%s", s.bucket, s.prefix, k)
+}
+
+func (s *S3Store) Get(k string) (io.ReadCloser, error) {
+
+	rsp, err := s.do("GET", s.objectKey(k), nil, nil, 0, S3_EMPTY_PAYLOAD_HASH)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.StatusCode != 200 {
+		defer rsp.Body.Close()
+		return nil, s3Error(rsp)
+	}
+
+	return rsp.Body, nil
+}
+
+// Put spools in (to a temporary file if it's big) so that its size and
+// checksum, which SigV4 signs, are known before it is uploaded.
+
+func (s *S3Store) Put(k string, in io.ReadCloser) error {
+
+	hr := util.NewHashingReader(in, sha256.New())
+
+	body, size, cleanup, err := spool(hr)
+
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	rsp, err := s.do("PUT", s.objectKey(k), nil, body, size, hr.Checksum())
+
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != 200 {
+		return s3Error(rsp)
+	}
+
+	return nil
+}
+
+// Open returns a writer whose contents are uploaded when it is closed.
+
+func (s *S3Store) Open(k string) (io.WriteCloser, error) {
+
+	pr, pw := io.Pipe()
+
+	f := s3File{
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+
+	go func() {
+
+		err := s.Put(k, pr)
+
+		// so that anything still writing finds out
+
+		pr.CloseWithError(err)
+		f.done <- err
+	}()
+
+	return &f, nil
+}
+
+// Delete doesn't fail if k doesn't exist, like storage.FSStore.
+
+func (s *S3Store) Delete(k string) error {
+
+	rsp, err := s.do("DELETE", s.objectKey(k), nil, nil, 0, S3_EMPTY_PAYLOAD_HASH)
+
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != 204 && rsp.StatusCode != 200 && rsp.StatusCode != 404 {
+		return s3Error(rsp)
+	}
+
+	return nil
+}
+
+func (s *S3Store) Exists(k string) (bool, error) {
+
+	rsp, err := s.do("HEAD", s.objectKey(k), nil, nil, 0, S3_EMPTY_PAYLOAD_HASH)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer rsp.Body.Close()
+
+	switch rsp.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	default:
+		return false, s3Error(rsp)
+	}
+}
+
+// s3ObjectInfo is the os.FileInfo for an object in a bucket listing.
+
+type s3ObjectInfo struct {
+	name     string
+	size     int64
+	mod_time time.Time
+}
+
+func (i *s3ObjectInfo) Name() string {
+	return i.name
+}
+
+func (i *s3ObjectInfo) Size() int64 {
+	return i.size
+}
+
+func (i *s3ObjectInfo) Mode() os.FileMode {
+	return 0644
+}
+
+func (i *s3ObjectInfo) ModTime() time.Time {
+	return i.mod_time
+}
+
+func (i *s3ObjectInfo) IsDir() bool {
+	return false
+}
+
+func (i *s3ObjectInfo) Sys() interface{} {
+	return nil
+}
+
+// Walk calls cb with the URI of every object under the store's prefix and
+// an os.FileInfo for it, like storage.FSStore, so that callers can get the
+// size of each object from the listing instead of asking for it.
+
+func (s *S3Store) Walk(cb storage.WalkFunc) error {
+
+	prefix := ""
+
+	if s.prefix != "" {
+		prefix = s.prefix + "/"
+	}
+
+	token := ""
+
+	for {
+
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+
+		rsp, err := s.do("GET", "", q, nil, 0, S3_EMPTY_PAYLOAD_HASH)
+
+		if err != nil {
+			return err
+		}
+
+		if rsp.StatusCode != 200 {
+			defer rsp.Body.Close()
+			return s3Error(rsp)
+		}
+
+		var result s3ListBucketResult
+
+		err = xml.NewDecoder(rsp.Body).Decode(&result)
+		rsp.Body.Close()
+
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range result.Contents {
+
+			k := strings.TrimPrefix(obj.Key, prefix)
+
+			info := s3ObjectInfo{
+				name:     path.Base(k),
+				size:     obj.Size,
+				mod_time: obj.LastModified,
+			}
+
+			err := cb(s.URI(k), &info)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+
+		token = result.NextContinuationToken
+	}
+
+	return nil
+}
+
+func (s *S3Store) objectKey(k string) string {
+
+	k = strings.TrimLeft(k, "/")
+
+	if s.prefix == "" {
+		return k
+	}
+
+	return s.prefix + "/" + k
+}
+
+// do makes a signed request for key (or the bucket itself if key is empty).
+// body, if not nil, must be size bytes long and hash to payload_hash. The
+// request is cancelled if it takes longer than s.timeout, including reading
+// the response body, which has to be closed.
+
+func (s *S3Store) do(method string, key string, query url.Values, body io.Reader, size int64, payload_hash string) (*http.Response, error) {
+
+	path := "/" + s3Escape(key, false)
+
+	if s.path_style {
+		path = strings.TrimRight(s.endpoint.Path, "/") + "/" + s3Escape(s.bucket, true) + path
+	}
+
+	canonical_query := s3CanonicalQuery(query)
+
+	u := fmt.Sprintf("%s://%s%s", s.endpoint.Scheme, s.endpoint.Host, path)
+
+	if canonical_query != "" {
+		u = u + "?" + canonical_query
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	s.sign(req, path, canonical_query, payload_hash, time.Now())
+
+	rsp, err := s.client.Do(req)
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	rsp.Body = &s3Body{
+		body:   rsp.Body,
+		cancel: cancel,
+	}
+
+	return rsp, nil
+}
+
+// sign adds the SigV4 headers to req. path and canonical_query must be
+// exactly what is being sent.
+
+func (s *S3Store) sign(req *http.Request, path string, canonical_query string, payload_hash string, t time.Time) {
+
+	now := t.UTC()
+	amz_date := now.Format("20060102T150405Z")
+	short_date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amz_date)
+	req.Header.Set("x-amz-content-sha256", payload_hash)
+
+	if s.session_token != "" {
+		req.Header.Set("x-amz-security-token", s.session_token)
+	}
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payload_hash,
+		"x-amz-date":           amz_date,
+	}
+
+	if s.session_token != "" {
+		headers["x-amz-security-token"] = s.session_token
+	}
+
+	names := make([]string, 0)
+
+	for k := range headers {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	canonical_headers := ""
+
+	for _, k := range names {
+		canonical_headers += k + ":" + strings.TrimSpace(headers[k]) + "\n"
+	}
+
+	signed_headers := strings.Join(names, ";")
+
+	canonical_request := strings.Join([]string{
+		req.Method,
+		path,
+		canonical_query,
+		canonical_headers,
+		signed_headers,
+		payload_hash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", short_date, s.region)
+
+	request_hash := sha256.Sum256([]byte(canonical_request))
+
+	string_to_sign := strings.Join([]string{
+		S3_SIGNING_ALGORITHM,
+		amz_date,
+		scope,
+		hex.EncodeToString(request_hash[:]),
+	}, "\n")
+
+	signing_key := s3HMAC([]byte("AWS4"+s.secret_key), short_date)
+	signing_key = s3HMAC(signing_key, s.region)
+	signing_key = s3HMAC(signing_key, "s3")
+	signing_key = s3HMAC(signing_key, "aws4_request")
+
+	sig := hex.EncodeToString(s3HMAC(signing_key, string_to_sign))
+
+	auth := fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", S3_SIGNING_ALGORITHM, s.access_key, scope, signed_headers, sig)
+	req.Header.Set("Authorization", auth)
+}
+
+func s3HMAC(key []byte, data string) []byte {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// s3Escape is RFC 3986 encoding, optionally leaving slashes alone, which is
+// what SigV4 wants for paths and query strings.
+
+func s3Escape(str string, encode_slash bool) string {
+
+	var buf bytes.Buffer
+
+	for _, b := range []byte(str) {
+
+		switch {
+		case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9':
+			buf.WriteByte(b)
+		case b == '-' || b == '_' || b == '.' || b == '~':
+			buf.WriteByte(b)
+		case b == '/' && !encode_slash:
+			buf.WriteByte(b)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+
+	return buf.String()
+}
+
+func s3CanonicalQuery(query url.Values) string {
+
+	if len(query) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0)
+
+	for k := range query {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	pairs := make([]string, 0)
+
+	for _, k := range keys {
+
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+
+		for _, v := range values {
+			pairs = append(pairs, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+func s3Error(rsp *http.Response) error {
+
+	body, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, 1024))
+
+	msg := fmt.Sprintf("S3 request failed: %s %s", rsp.Status, strings.TrimSpace(string(body)))
+	return errors.New(msg)
+}
@@ -0,0 +1,70 @@
+package stores
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// anything bigger than this is spooled to a temporary file, rather than held
+// in memory, while we work out how big it is
+
+const SPOOL_MAX_MEMORY int64 = 1024 * 1024
+
+// spool reads in and returns a reader for its contents along with their size,
+// using a temporary file for anything larger than SPOOL_MAX_MEMORY. cleanup
+// must always be called.
+
+func spool(in io.ReadCloser) (io.Reader, int64, func(), error) {
+
+	defer in.Close()
+
+	noop := func() {}
+
+	var buf bytes.Buffer
+
+	n, err := io.CopyN(&buf, in, SPOOL_MAX_MEMORY+1)
+
+	if err == io.EOF {
+		return bytes.NewReader(buf.Bytes()), n, noop, nil
+	}
+
+	if err != nil {
+		return nil, 0, noop, err
+	}
+
+	tmp, err := ioutil.TempFile("", "flickr-archive-spool-")
+
+	if err != nil {
+		return nil, 0, noop, err
+	}
+
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+
+	_, err = io.Copy(tmp, io.MultiReader(&buf, in))
+
+	if err != nil {
+		cleanup()
+		return nil, 0, noop, err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+
+	if err != nil {
+		cleanup()
+		return nil, 0, noop, err
+	}
+
+	_, err = tmp.Seek(0, io.SeekStart)
+
+	if err != nil {
+		cleanup()
+		return nil, 0, noop, err
+	}
+
+	return tmp, size, cleanup, nil
+}
@@ -0,0 +1,211 @@
+package stores
+
+// a registry of storage.Store constructors keyed by URI scheme, so that the
+// tools can be told where to archive things with a single -storage flag:
+//
+// fs:///path/to/archive (or just /path/to/archive)
+// mem://
+// s3://bucket/prefix?region=us-east-1
+// tar:///path/to/archive.tar.gz
+// zip:///path/to/archive.zip
+//
+// Some stores (tar and zip) need to be closed when you're done with them;
+// use Close, which does nothing for stores that don't.
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-storage"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type StoreInitializeFunc func(*url.URL) (storage.Store, error)
+
+var registry = make(map[string]StoreInitializeFunc)
+
+var registry_mu = new(sync.RWMutex)
+
+func init() {
+
+	Register("fs", NewFSStoreWithURI)
+	Register("mem", NewMemStoreWithURI)
+	Register("s3", NewS3StoreWithURI)
+	Register("tar", NewTarStoreWithURI)
+	Register("zip", NewZipStoreWithURI)
+}
+
+// Register makes a storage.Store constructor available for URIs with scheme.
+// It returns an error if scheme is already registered.
+
+func Register(scheme string, fn StoreInitializeFunc) error {
+
+	registry_mu.Lock()
+	defer registry_mu.Unlock()
+
+	scheme = strings.ToLower(scheme)
+
+	_, exists := registry[scheme]
+
+	if exists {
+		msg := fmt.Sprintf("Storage scheme '%s' is already registered", scheme)
+		return errors.New(msg)
+	}
+
+	registry[scheme] = fn
+	return nil
+}
+
+func Schemes() []string {
+
+	registry_mu.RLock()
+	defer registry_mu.RUnlock()
+
+	schemes := make([]string, 0)
+
+	for s := range registry {
+		schemes = append(schemes, s+"://")
+	}
+
+	sort.Strings(schemes)
+	return schemes
+}
+
+// tar and zip archives are streamed, so nothing that has been written to one
+// can be read back and an existing one can't be added to
+
+var write_only = map[string]bool{
+	"tar": true,
+	"zip": true,
+}
+
+// Scheme returns the (lower case) scheme of the store for uri, following the
+// same rules as NewStore.
+
+func Scheme(uri string) string {
+
+	if strings.HasPrefix(uri, "root=") || !strings.Contains(uri, "://") {
+		return "fs"
+	}
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Scheme)
+}
+
+// IsWriteOnly reports whether the store for uri can only be written to,
+// which rules out anything that needs to read an archive back: journals,
+// checkpoints, manifests and so on.
+
+func IsWriteOnly(uri string) bool {
+	return write_only[Scheme(uri)]
+}
+
+// RequireReadable returns an error if the store for uri is write-only, for
+// commands and flags (what) that need to read an archive back. Opening a
+// tar or zip archive to read it would also replace it.
+
+func RequireReadable(uri string, what string) error {
+
+	if !IsWriteOnly(uri) {
+		return nil
+	}
+
+	msg := fmt.Sprintf("%s can not be used with %s:// storage, which is write-only", what, Scheme(uri))
+	return errors.New(msg)
+}
+
+// IsWriteOnlyStore is IsWriteOnly for a store that has already been created.
+
+func IsWriteOnlyStore(store storage.Store) bool {
+
+	_, ok := store.(*archiveStore)
+	return ok
+}
+
+// NewStore returns the storage.Store for uri. Anything that doesn't look like
+// a URI is treated as a path on the local filesystem, as is a go-storage
+// "root=/path" DSN, so that existing -storage flags keep working.
+
+func NewStore(uri string) (storage.Store, error) {
+
+	if strings.HasPrefix(uri, "root=") {
+		uri = strings.TrimPrefix(uri, "root=")
+	}
+
+	if !strings.Contains(uri, "://") {
+
+		u := url.URL{
+			Scheme: "fs",
+			Path:   uri,
+		}
+
+		return NewFSStoreWithURI(&u)
+	}
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	registry_mu.RLock()
+	fn, ok := registry[strings.ToLower(u.Scheme)]
+	registry_mu.RUnlock()
+
+	if !ok {
+		msg := fmt.Sprintf("Unsupported storage scheme '%s', valid options are: %s", u.Scheme, strings.Join(Schemes(), ", "))
+		return nil, errors.New(msg)
+	}
+
+	return fn(u)
+}
+
+// Close closes store if it needs closing. It should be called even if
+// something went wrong, so that tar and zip files are still readable.
+
+func Close(store storage.Store) error {
+
+	c, ok := store.(io.Closer)
+
+	if !ok {
+		return nil
+	}
+
+	return c.Close()
+}
+
+// NewFSStoreWithURI returns an AtomicFSStore for fs:///absolute/path or
+// fs://relative/path, creating the directory if necessary.
+
+func NewFSStoreWithURI(u *url.URL) (storage.Store, error) {
+
+	path := u.Host + u.Path
+
+	if path == "" {
+		return nil, errors.New("Missing path for filesystem storage")
+	}
+
+	abs_path, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(abs_path, FS_DIR_PERMS)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAtomicFSStore(abs_path)
+}
@@ -0,0 +1,142 @@
+package stores
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-storage"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const TAR_COMPRESSION_NONE string = "none"
+
+const TAR_COMPRESSION_GZIP string = "gzip"
+
+const TAR_COMPRESSION_ZSTD string = "zstd"
+
+type tarWriter struct {
+	archiveWriter
+	fh         *os.File
+	compressor io.WriteCloser
+	tw         *tar.Writer
+}
+
+// NewTarStoreWithURI returns a store that writes to tar:///path/to/file.tar
+// which is compressed if the file name ends in .gz, .tgz, .zst or .tzst, or
+// if there is a ?compression=none|gzip|zstd parameter.
+
+func NewTarStoreWithURI(u *url.URL) (storage.Store, error) {
+
+	path, err := archivePath(u)
+
+	if err != nil {
+		return nil, err
+	}
+
+	compression := u.Query().Get("compression")
+
+	if compression == "" {
+		compression = tarCompression(path)
+	}
+
+	fh, err := os.Create(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var out io.Writer = fh
+	var compressor io.WriteCloser
+
+	switch compression {
+	case TAR_COMPRESSION_NONE:
+		// pass
+	case TAR_COMPRESSION_GZIP:
+		compressor = gzip.NewWriter(fh)
+	case TAR_COMPRESSION_ZSTD:
+
+		enc, err := zstd.NewWriter(fh)
+
+		if err != nil {
+			fh.Close()
+			return nil, err
+		}
+
+		compressor = enc
+	default:
+		fh.Close()
+		msg := fmt.Sprintf("Invalid tar compression '%s'", compression)
+		return nil, errors.New(msg)
+	}
+
+	if compressor != nil {
+		out = compressor
+	}
+
+	w := tarWriter{
+		fh:         fh,
+		compressor: compressor,
+		tw:         tar.NewWriter(out),
+	}
+
+	return newArchiveStore(path, &w), nil
+}
+
+func (w *tarWriter) WriteEntry(key string, r io.Reader, size int64) error {
+
+	hdr := tar.Header{
+		Name:    key,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+
+	err := w.tw.WriteHeader(&hdr)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w.tw, r)
+	return err
+}
+
+func (w *tarWriter) Close() error {
+
+	err := w.tw.Close()
+
+	if err != nil {
+		w.fh.Close()
+		return err
+	}
+
+	if w.compressor != nil {
+
+		err := w.compressor.Close()
+
+		if err != nil {
+			w.fh.Close()
+			return err
+		}
+	}
+
+	return w.fh.Close()
+}
+
+func tarCompression(path string) string {
+
+	switch {
+	case strings.HasSuffix(path, ".gz"), strings.HasSuffix(path, ".tgz"):
+		return TAR_COMPRESSION_GZIP
+	case strings.HasSuffix(path, ".zst"), strings.HasSuffix(path, ".tzst"):
+		return TAR_COMPRESSION_ZSTD
+	default:
+		return TAR_COMPRESSION_NONE
+	}
+}
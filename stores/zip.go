@@ -0,0 +1,84 @@
+package stores
+
+import (
+	"archive/zip"
+	"github.com/aaronland/go-storage"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type zipWriter struct {
+	archiveWriter
+	fh *os.File
+	zw *zip.Writer
+}
+
+// NewZipStoreWithURI returns a store that writes to zip:///path/to/file.zip
+// in a single pass, which zip allows because sizes go after each entry.
+
+func NewZipStoreWithURI(u *url.URL) (storage.Store, error) {
+
+	path, err := archivePath(u)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fh, err := os.Create(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	w := zipWriter{
+		fh: fh,
+		zw: zip.NewWriter(fh),
+	}
+
+	return newArchiveStore(path, &w), nil
+}
+
+func (w *zipWriter) WriteEntry(key string, r io.Reader, size int64) error {
+
+	// photos and videos are already compressed so there's no point in
+	// trying again
+
+	method := zip.Store
+
+	switch filepath.Ext(key) {
+	case ".json", ".txt", ".xml":
+		method = zip.Deflate
+	}
+
+	hdr := zip.FileHeader{
+		Name:     key,
+		Method:   method,
+		Modified: time.Now(),
+	}
+
+	hdr.SetMode(0644)
+
+	out, err := w.zw.CreateHeader(&hdr)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, r)
+	return err
+}
+
+func (w *zipWriter) Close() error {
+
+	err := w.zw.Close()
+
+	if err != nil {
+		w.fh.Close()
+		return err
+	}
+
+	return w.fh.Close()
+}
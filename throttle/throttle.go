@@ -0,0 +1,135 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// Throttle limits how often something (an API call, a download) happens.
+// The same Throttle can be shared by any number of goroutines and clients,
+// which is how the Flickr API and photo downloads are kept under one limit.
+
+type Throttle interface {
+	// Wait blocks until the caller is allowed to proceed or ctx is done.
+	Wait(context.Context) error
+	// SetRate changes the number of events allowed per second. A rate of
+	// zero or less means no limit.
+	SetRate(float64)
+	Rate() float64
+}
+
+// TokenBucket is a Throttle that allows rate events per second on average and
+// up to burst at once. Unlike time.Tick it doesn't keep a goroutine (or a
+// ticker) around so there is nothing to leak when it's no longer used.
+
+type TokenBucket struct {
+	Throttle
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mu     *sync.Mutex
+}
+
+func NewTokenBucket(rate float64, burst int) (Throttle, error) {
+
+	if burst < 1 {
+		return nil, errors.New("Burst must be at least 1")
+	}
+
+	b := TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		mu:     new(sync.Mutex),
+	}
+
+	return &b, nil
+}
+
+func (b *TokenBucket) Wait(ctx context.Context) error {
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		// pass
+	}
+
+	b.mu.Lock()
+
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+
+	b.refill(time.Now())
+
+	// take a token whether or not there is one; if there isn't then the
+	// balance goes negative and we wait until it would have been paid
+	// back, which keeps waiters in the order they arrived
+
+	b.tokens -= 1
+
+	if b.tokens >= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+
+	wait := time.Duration(math.Ceil(-b.tokens / b.rate * float64(time.Second)))
+
+	b.mu.Unlock()
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+
+		// give the token back so that the callers after us don't
+		// wait for a request that never happened
+
+		b.mu.Lock()
+		b.tokens += 1
+		b.mu.Unlock()
+
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (b *TokenBucket) SetRate(rate float64) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// tokens earned so far are earned at the old rate
+
+	b.refill(time.Now())
+	b.rate = rate
+}
+
+func (b *TokenBucket) Rate() float64 {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.rate
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+
+	if elapsed <= 0 || b.rate <= 0 {
+		return
+	}
+
+	b.tokens = math.Min(b.burst, b.tokens+(elapsed*b.rate))
+}
@@ -12,6 +12,7 @@ type User interface {
 	Username() string
 	ID() string
 	DateFirstPhoto() time.Time
+	DateFirstPhotoTaken() time.Time
 }
 
 type ArchiveUser struct {
@@ -19,6 +20,7 @@ type ArchiveUser struct {
 	username string
 	nsid     string
 	first    time.Time // please rename me...
+	taken    time.Time
 }
 
 func NewArchiveUserForUsername(api flickr.API, username string) (User, error) {
@@ -53,13 +55,35 @@ func NewArchiveUserForUsername(api flickr.API, username string) (User, error) {
 		return nil, errors.New("can't find NSID")
 	}
 
+	// in UTC so that nothing depends on the timezone of the machine
+	// we happen to be running on
+
 	first_ts := first.Int()
-	dt := time.Unix(first_ts, 0)
+	dt := time.Unix(first_ts, 0).UTC()
+
+	// dates taken are wall-clock times without a timezone, which
+	// time.Parse reads as UTC; if there
+	// isn't one (no photos, or none with a date taken) fall back to
+	// the first upload date
+
+	taken := dt
+
+	first_taken := gjson.GetBytes(info, "person.photos.firstdatetaken._content")
+
+	if first_taken.Exists() && first_taken.String() != "" {
+
+		t, err := time.Parse("2006-01-02 15:04:05", first_taken.String())
+
+		if err == nil {
+			taken = t
+		}
+	}
 
 	user := ArchiveUser{
 		username: username,
 		nsid:     nsid.String(),
 		first:    dt,
+		taken:    taken,
 	}
 
 	return &user, nil
@@ -76,3 +100,7 @@ func (u *ArchiveUser) ID() string {
 func (u *ArchiveUser) DateFirstPhoto() time.Time {
 	return u.first
 }
+
+func (u *ArchiveUser) DateFirstPhotoTaken() time.Time {
+	return u.taken
+}
@@ -0,0 +1,86 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/aaronland/go-storage"
+	"hash"
+	"io"
+	"io/ioutil"
+)
+
+// HashingReader works out the size and checksum of everything read through
+// it, so files can be checksummed as they're written instead of being read
+// back afterwards.
+
+type HashingReader struct {
+	r    io.ReadCloser
+	hash hash.Hash
+	size int64
+}
+
+func NewHashingReader(r io.ReadCloser, h hash.Hash) *HashingReader {
+
+	hr := HashingReader{
+		r:    r,
+		hash: h,
+	}
+
+	return &hr
+}
+
+func (hr *HashingReader) Read(b []byte) (int, error) {
+
+	n, err := hr.r.Read(b)
+
+	hr.hash.Write(b[:n])
+	hr.size += int64(n)
+
+	return n, err
+}
+
+func (hr *HashingReader) Close() error {
+	return hr.r.Close()
+}
+
+// Size returns the number of bytes that have been read.
+
+func (hr *HashingReader) Size() int64 {
+	return hr.size
+}
+
+// Checksum returns the hex-encoded checksum of everything that has been read.
+
+func (hr *HashingReader) Checksum() string {
+	return hex.EncodeToString(hr.hash.Sum(nil))
+}
+
+func ReadKey(store storage.Store, key string) ([]byte, error) {
+
+	fh, err := store.Get(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	return ioutil.ReadAll(fh)
+}
+
+// PutKey replaces key with body (see Replace) and returns its SHA-256
+// checksum.
+
+func PutKey(store storage.Store, key string, body []byte) (string, error) {
+
+	hr := NewHashingReader(ioutil.NopCloser(bytes.NewReader(body)), sha256.New())
+
+	err := Replace(store, key, hr)
+
+	if err != nil {
+		return "", err
+	}
+
+	return hr.Checksum(), nil
+}
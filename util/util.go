@@ -1,10 +1,15 @@
 package util
 
 import (
+	"bytes"
+	"github.com/aaronland/go-storage"
 	"github.com/facebookgo/atomicfile"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func GetStore(remote string, local string) error {
@@ -44,14 +49,25 @@ func ReadFile(path string) ([]byte, error) {
 }
 
 func WriteFile(path string, body []byte) error {
+	return WriteFileWithPerms(path, body, 0644)
+}
+
+func WriteFileWithPerms(path string, body []byte, perms os.FileMode) error {
+	return WriteFileWithReader(path, bytes.NewReader(body), perms)
+}
+
+// WriteFileWithReader is WriteFileWithPerms for things that are too big to
+// hold in memory. Nothing is written to path unless all of in is.
+
+func WriteFileWithReader(path string, in io.Reader, perms os.FileMode) error {
 
-	fh, err := atomicfile.New(path, 0644) // custom perms?
+	fh, err := atomicfile.New(path, perms)
 
 	if err != nil {
 		return err
 	}
 
-	_, err = fh.Write(body)
+	_, err = io.Copy(fh, in)
 
 	if err != nil {
 		fh.Abort()
@@ -67,3 +83,41 @@ func WriteFile(path string, body []byte) error {
 
 	return nil
 }
+
+// StoreKey returns the key for path relative to the root of store. This is
+// necessary because storage.Store.Walk passes fully qualified paths (or URIs)
+// to its callback but everything else expects keys.
+
+func StoreKey(store storage.Store, path string) string {
+
+	root := store.URI("")
+
+	key := strings.TrimPrefix(path, root)
+	key = strings.TrimLeft(key, "/")
+
+	return filepath.ToSlash(key)
+}
+
+// Replace writes in to key in store, replacing whatever is already there.
+// storage.FSStore doesn't truncate existing files, so one that gets shorter
+// (an updated info response, say) would be left with trailing junk, which
+// means they have to be removed first. Every other store, including
+// stores.AtomicFSStore which swaps the new file in all at once, replaces
+// them as part of Put.
+
+func Replace(store storage.Store, key string, in io.ReadCloser) error {
+
+	_, is_fs := store.(*storage.FSStore)
+
+	if is_fs {
+
+		err := store.Delete(key)
+
+		if err != nil {
+			in.Close()
+			return err
+		}
+	}
+
+	return store.Put(key, in)
+}